@@ -6,11 +6,73 @@ const (
 	DefaultCacheThresholdBytes       = 10000 // 10Kb
 	DefaultPaginationDefaultPageSize = 50
 	DefaultPaginationMaxPageSize     = 1000
+	DefaultShutdownTimeout           = 20 * time.Second
+	DefaultStartupPolicy             = "fail"
+
+	DefaultBackendCallTimeout                = 60 * time.Second
+	DefaultBackendCallRetryMaxAttempts       = 1
+	DefaultBackendCallRetryInitialBackoff    = 100 * time.Millisecond
+	DefaultBackendCallRetryMaxBackoff        = 2 * time.Second
+	DefaultBackendCircuitBreakerThreshold    = 5
+	DefaultBackendCircuitBreakerOpenDuration = 30 * time.Second
+	DefaultToolResultsCacheMaxEntries        = 1000
+	DefaultToolResultsCacheMaxBytes          = 64 << 20 // 64Mb
+
+	DefaultJWTIntrospectionTimeout = 10 * time.Second
+	DefaultJWKSCacheInterval       = 5 * time.Minute
+	DefaultJWKSMinRefreshInterval  = 10 * time.Second
+	DefaultDPoPProofMaxAge         = 60 * time.Second
+	DefaultAPIKeysReloadInterval   = 30 * time.Second
+
+	DefaultTLSReloadInterval = 30 * time.Second
+
+	DefaultMetricsHost = ":9090"
+
+	DefaultTracingExporter     = "otlp"
+	DefaultTracingOTLPEndpoint = "localhost:4318"
+	DefaultTracingSampleRatio  = 1.0
+
+	DefaultAuthorizationServerMetadataCacheTTL = time.Hour
+	DefaultOAuthProxyTokenTTL                  = 15 * time.Minute
 )
 
+// DefaultJWTRequiredClaims are the claims required in every token when they are not configured
+var DefaultJWTRequiredClaims = []string{"exp"}
+
+// DefaultOAuthProxyScopes are the scopes requested to the IdP by the built-in authorization server when they are not configured
+var DefaultOAuthProxyScopes = []string{"openid", "profile", "email"}
+
+// DefaultOAuthProxyForwardedClaims are the claims copied into the tokens of the built-in authorization server when they are not configured
+var DefaultOAuthProxyForwardedClaims = []string{"email", "name", "preferred_username", "groups"}
+
+// DefaultClientRegistrationGrantTypes are the grant types allowed to registered clients when they are not configured
+var DefaultClientRegistrationGrantTypes = []string{"authorization_code"}
+
+// ServerTransportHTTPTLSConfig represents the TLS configuration of the HTTP transport.
+// Certificates are reloaded when their files change, so they can be renewed without restarting
+type ServerTransportHTTPTLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientCAFile enables mutual TLS, verifying client certificates against this CA bundle
+	ClientCAFile string `yaml:"client_ca_file,omitempty"`
+
+	// ClientAuth values: 'request' (verify if given) or 'require'. Default: 'require' when ClientCAFile is set
+	ClientAuth string `yaml:"client_auth,omitempty"`
+
+	// ReloadInterval is the time between checks for changes in the files
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+}
+
 // ServerTransportHTTPConfig represents the HTTP transport configuration
 type ServerTransportHTTPConfig struct {
-	Host string `yaml:"host"`
+	Host string                       `yaml:"host"`
+	TLS  ServerTransportHTTPTLSConfig `yaml:"tls,omitempty"`
+
+	// TrustForwardedHeaders takes the public origin from 'X-Forwarded-Proto' and 'X-Forwarded-Host'
+	// when 'oauth_protected_resource.resource' does not set it. Only enable it behind a proxy overwriting them
+	TrustForwardedHeaders bool `yaml:"trust_forwarded_headers,omitempty"`
 }
 
 // ServerTransportConfig represents the transport configuration
@@ -23,6 +85,47 @@ type ServerOptionsConfig struct {
 	CacheThresholdBytes       int `yaml:"cache_threshold_bytes,omitempty"`
 	PaginationDefaultPageSize int `yaml:"pagination_default_page_size,omitempty"`
 	PaginationMaxPageSize     int `yaml:"pagination_max_page_size,omitempty"`
+
+	// Time given to in-flight requests to finish when the proxy is stopped. They are cancelled after it
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout,omitempty"`
+
+	// StartupPolicy values: 'fail', 'lazy' or 'degraded'. It decides what happens when the backend
+	// or the identity providers are not reachable on startup
+	StartupPolicy string `yaml:"startup_policy,omitempty"`
+}
+
+// ServerMetricsConfig represents the Prometheus metrics endpoint configuration
+type ServerMetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Host of the dedicated listener for '/metrics'. It is never served along with '/mcp', as it is not authenticated
+	Host string `yaml:"host,omitempty"`
+}
+
+// ServerTracingOTLPConfig represents the configuration of the OTLP exporter.
+// Traces are sent over HTTP, so collectors must enable that receiver
+type ServerTracingOTLPConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Insecure sends the traces without TLS. It is a pointer, as it defaults to true only for loopback endpoints
+	Insecure *bool             `yaml:"insecure,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+}
+
+// ServerTracingConfig represents the OpenTelemetry tracing configuration
+type ServerTracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Exporter values: 'otlp' or 'stdout'
+	Exporter string                  `yaml:"exporter,omitempty"`
+	OTLP     ServerTracingOTLPConfig `yaml:"otlp,omitempty"`
+
+	// Fraction of the traces started by the proxy that are sampled. Traces started by clients keep their decision.
+	// It is a pointer, as zero disables sampling and the default is only used when unset
+	SampleRatio *float64 `yaml:"sample_ratio,omitempty"`
+
+	// Baggage propagates the W3C baggage of the clients to the backend, besides the trace context
+	Baggage bool `yaml:"baggage,omitempty"`
 }
 
 // ServerConfig represents the server configuration section
@@ -31,6 +134,8 @@ type ServerConfig struct {
 	Version   string                `yaml:"version"`
 	Transport ServerTransportConfig `yaml:"transport,omitempty"`
 	Options   ServerOptionsConfig   `yaml:"options,omitempty"`
+	Metrics   ServerMetricsConfig   `yaml:"metrics,omitempty"`
+	Tracing   ServerTracingConfig   `yaml:"tracing,omitempty"`
 }
 
 // AccessLogsConfig represents the AccessLogs middleware configuration
@@ -39,13 +144,91 @@ type AccessLogsConfig struct {
 	RedactedHeaders []string `yaml:"redacted_headers"`
 }
 
-// JWTValidationLocalConfig represents the local JWT validation configuration
-type JWTValidationLocalConfig struct {
-	JWKSUri         string                        `yaml:"jwks_uri"`
-	CacheInterval   time.Duration                 `yaml:"cache_interval"`
+// JWTValidationJWKSConfig represents the source of the keys used to validate JWTs.
+// Keys are obtained from a remote URI, a local file or inline JSON, in that order of preference
+type JWTValidationJWKSConfig struct {
+	JWKSUri  string `yaml:"jwks_uri,omitempty"`
+	JWKSFile string `yaml:"jwks_file,omitempty"`
+	JWKS     string `yaml:"jwks,omitempty"`
+
+	// CacheInterval is the time between refreshes, used when the remote does not send cache headers
+	CacheInterval time.Duration `yaml:"cache_interval,omitempty"`
+
+	// MinRefreshInterval limits how often keys are refreshed, even when tokens with unknown 'kid' arrive
+	MinRefreshInterval time.Duration `yaml:"min_refresh_interval,omitempty"`
+}
+
+// JWTValidationIssuerConfig represents an issuer trusted by the local JWT validation
+type JWTValidationIssuerConfig struct {
+	Issuer                  string `yaml:"issuer"`
+	JWTValidationJWKSConfig `yaml:",inline"`
+
+	// Discovery obtains the JWKS URI from the OIDC metadata of the issuer, when it is not set
+	Discovery bool `yaml:"discovery,omitempty"`
+
+	Audiences       []string                      `yaml:"audiences,omitempty"`
+	Algorithms      []string                      `yaml:"algorithms,omitempty"`
 	AllowConditions []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
 }
 
+// JWTValidationLocalConfig represents the local JWT validation configuration.
+// When no issuers are listed, the issuer of the authorization server and the protected resource are trusted
+type JWTValidationLocalConfig struct {
+	JWTValidationJWKSConfig `yaml:",inline"`
+	AllowConditions         []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+	Issuers                 []JWTValidationIssuerConfig   `yaml:"issuers,omitempty"`
+
+	// Algorithms allowed to sign tokens. Asymmetric algorithms are allowed when empty, as HMAC ones are never accepted
+	Algorithms []string `yaml:"algorithms,omitempty"`
+
+	// Leeway is the clock skew tolerated when checking time-based claims ('exp', 'nbf', 'iat')
+	Leeway time.Duration `yaml:"leeway,omitempty"`
+
+	// RequiredClaims must be present in every token. 'exp' and 'iat' are also validated.
+	// Defaults to 'exp' when missing, while an empty list requires none
+	RequiredClaims []string `yaml:"required_claims,omitempty"`
+}
+
+// JWTValidationIntrospectionConfig represents the OAuth 2.0 token introspection (RFC 7662) configuration
+type JWTValidationIntrospectionConfig struct {
+	Endpoint     string        `yaml:"endpoint"`
+	ClientID     string        `yaml:"client_id"`
+	ClientSecret string        `yaml:"client_secret"`
+	Timeout      time.Duration `yaml:"timeout,omitempty"`
+
+	// CacheMaxTTL caps how long active results are cached. Zero means until token expiration
+	CacheMaxTTL time.Duration `yaml:"cache_max_ttl,omitempty"`
+
+	// Audiences accepted in the 'aud' of active tokens. Default: 'oauth_protected_resource.resource'
+	Audiences []string `yaml:"audiences,omitempty"`
+}
+
+// JWTValidationAPIKeyConfig represents a static API key accepted by the 'api_key' strategy.
+// Only the hash of the key is stored. Metadata is exposed to allowance conditions as the token payload
+type JWTValidationAPIKeyConfig struct {
+	Name string `yaml:"name"`
+
+	// Hash is the hex-encoded SHA-256 of the key, optionally prefixed by 'sha256:'
+	Hash string `yaml:"hash"`
+
+	Groups []string `yaml:"groups,omitempty"`
+	Scopes []string `yaml:"scopes,omitempty"`
+
+	// ExpiresAt is the time when the key stops being accepted. Keys never expire when it is not set
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+}
+
+// JWTValidationAPIKeysConfig represents the static API keys validation configuration.
+// Keys from the file are merged with inline ones, and reloaded when the file changes
+type JWTValidationAPIKeysConfig struct {
+	// Header carrying the key. Keys are read from the 'Authorization' header with 'Bearer' scheme when empty
+	Header string `yaml:"header,omitempty"`
+
+	Keys           []JWTValidationAPIKeyConfig `yaml:"keys,omitempty"`
+	KeysFile       string                      `yaml:"keys_file,omitempty"`
+	ReloadInterval time.Duration               `yaml:"reload_interval,omitempty"`
+}
+
 // JWTValidationAllowCondition represents a condition for allowing a request after the local JWT validation configuration
 type JWTValidationAllowCondition struct {
 	Expression string `yaml:"expression"`
@@ -53,15 +236,75 @@ type JWTValidationAllowCondition struct {
 
 // JWTValidationConfig represents the JWT validation configuration
 type JWTValidationConfig struct {
-	Strategy        string                   `yaml:"strategy"`
-	ForwardedHeader string                   `yaml:"forwarded_header,omitempty"`
-	Local           JWTValidationLocalConfig `yaml:"local,omitempty"`
+	Strategy        string                           `yaml:"strategy"`
+	ForwardedHeader string                           `yaml:"forwarded_header,omitempty"`
+	Local           JWTValidationLocalConfig         `yaml:"local,omitempty"`
+	Introspection   JWTValidationIntrospectionConfig `yaml:"introspection,omitempty"`
+	APIKey          JWTValidationAPIKeysConfig       `yaml:"api_key,omitempty"`
+
+	// AllowConditions are evaluated for every strategy, after the ones defined under 'local'
+	AllowConditions []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+}
+
+// JWTScopesMethodRuleConfig represents the scopes required to call MCP methods matching a pattern
+type JWTScopesMethodRuleConfig struct {
+	Method string   `yaml:"method"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// JWTScopesToolRuleConfig represents the scopes required to call tools matching a name pattern or an annotation.
+// Annotation values: 'read_only', 'destructive', 'idempotent' or 'open_world'
+type JWTScopesToolRuleConfig struct {
+	Tool       string   `yaml:"tool,omitempty"`
+	Annotation string   `yaml:"annotation,omitempty"`
+	Scopes     []string `yaml:"scopes"`
+}
+
+// JWTScopesPromptRuleConfig represents the scopes required to use prompts matching a name pattern
+type JWTScopesPromptRuleConfig struct {
+	Prompt string   `yaml:"prompt"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// JWTScopesResourceRuleConfig represents the scopes required to use resources matching a URI pattern
+type JWTScopesResourceRuleConfig struct {
+	Resource string   `yaml:"resource"`
+	Scopes   []string `yaml:"scopes"`
+}
+
+// JWTScopesConfig represents the scopes required to perform requests.
+// Scopes of every matching rule are required
+type JWTScopesConfig struct {
+	Methods []JWTScopesMethodRuleConfig `yaml:"methods,omitempty"`
+	Tools   []JWTScopesToolRuleConfig   `yaml:"tools,omitempty"`
+
+	// Prompts and resources rules also apply to the completion of their arguments
+	Prompts   []JWTScopesPromptRuleConfig   `yaml:"prompts,omitempty"`
+	Resources []JWTScopesResourceRuleConfig `yaml:"resources,omitempty"`
+}
+
+// JWTDPoPConfig represents the validation of DPoP proofs (RFC 9449) for sender-constrained tokens.
+// Tokens bound to a key ('cnf.jkt') always require a valid proof when enabled
+type JWTDPoPConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Required rejects tokens not bound to a DPoP key
+	Required bool `yaml:"required,omitempty"`
+
+	// Algorithms allowed to sign proofs. 'dpop_signing_alg_values_supported' or asymmetric ones are used when empty
+	Algorithms []string `yaml:"algorithms,omitempty"`
+
+	// ProofMaxAge is the maximum age of a proof, according to its 'iat' claim.
+	// Proof IDs ('jti') are remembered during this time to reject replays
+	ProofMaxAge time.Duration `yaml:"proof_max_age,omitempty"`
 }
 
 // JWTConfig represents the JWT middleware configuration
 type JWTConfig struct {
 	Enabled    bool                `yaml:"enabled"`
 	Validation JWTValidationConfig `yaml:"validation,omitempty"`
+	Scopes     JWTScopesConfig     `yaml:"scopes,omitempty"`
+	DPoP       JWTDPoPConfig       `yaml:"dpop,omitempty"`
 }
 
 // MiddlewareConfig represents the middleware configuration section
@@ -70,10 +313,91 @@ type MiddlewareConfig struct {
 	JWT        JWTConfig        `yaml:"jwt,omitempty"`
 }
 
+// OAuthStaticClientConfig represents a pre-provisioned client handed out by the client registration facade
+type OAuthStaticClientConfig struct {
+	// ClientName is a pattern matched against the 'client_name' of registrations. Every registration matches when empty
+	ClientName   string `yaml:"client_name,omitempty"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret,omitempty"`
+
+	// RedirectUris are patterns the redirect URIs of the client must match on the built-in authorization server.
+	// Patterns of the registration policy are used when empty
+	RedirectUris []string `yaml:"redirect_uris,omitempty"`
+}
+
+// OAuthUpstreamRegistrationConfig represents the registration endpoint of the IdP, used to create clients there
+type OAuthUpstreamRegistrationConfig struct {
+	Endpoint           string `yaml:"endpoint"`
+	InitialAccessToken string `yaml:"initial_access_token,omitempty"`
+}
+
+// OAuthClientRegistrationConfig represents the Dynamic Client Registration (RFC 7591) facade configuration.
+// Registrations meeting the policy get a pre-provisioned client ('static') or one created in the IdP ('upstream')
+type OAuthClientRegistrationConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Strategy string `yaml:"strategy"`
+
+	// AllowedRedirectUris are patterns every redirect URI of a registration must match
+	AllowedRedirectUris []string `yaml:"allowed_redirect_uris,omitempty"`
+	AllowedGrantTypes   []string `yaml:"allowed_grant_types,omitempty"`
+
+	// InitialAccessToken is required to get confidential pre-provisioned clients, so their secrets
+	// are never handed out to anonymous registrations. Only public clients are issued when empty
+	InitialAccessToken string `yaml:"initial_access_token,omitempty"`
+
+	Clients  []OAuthStaticClientConfig       `yaml:"clients,omitempty"`
+	Upstream OAuthUpstreamRegistrationConfig `yaml:"upstream,omitempty"`
+}
+
+// OAuthProxyConfig represents the built-in authorization server, which performs the code flow with the IdP
+// on behalf of the clients and issues its own short-lived tokens
+type OAuthProxyConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Issuer of the tokens. Default: origin of 'oauth_protected_resource.resource'
+	Issuer string `yaml:"issuer,omitempty"`
+
+	// Client of the proxy in the IdP. Its redirect URI is '{public_origin}/callback'
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+
+	// SigningKeyFile is a PEM private key (RSA, EC or Ed25519) to sign tokens.
+	// An ephemeral key is generated when empty, so tokens are not valid after restarting
+	SigningKeyFile string `yaml:"signing_key_file,omitempty"`
+
+	TokenTTL time.Duration `yaml:"token_ttl,omitempty"`
+
+	// ForwardedClaims are the claims of the user copied from the IdP into the tokens
+	ForwardedClaims []string `yaml:"forwarded_claims,omitempty"`
+
+	// ScopeMappings grant scopes to users by their claims. When empty, scopes are the ones granted by the IdP
+	ScopeMappings []OAuthProxyScopeMappingConfig `yaml:"scope_mappings,omitempty"`
+}
+
+// OAuthProxyScopeMappingConfig grants a scope to the users whose claim has any of the values
+type OAuthProxyScopeMappingConfig struct {
+	Scope  string   `yaml:"scope"`
+	Claim  string   `yaml:"claim"`
+	Values []string `yaml:"values"`
+}
+
 // OAuthAuthorizationServer represents the OAuth Authorization Server configuration
 type OAuthAuthorizationServer struct {
 	Enabled   bool   `yaml:"enabled"`
 	IssuerUri string `yaml:"issuer_uri"`
+
+	// CacheTTL is how long the metadata of the issuer is cached before fetching it again
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+
+	// Overrides are fields replaced or added to the metadata of the issuer. Null values remove the fields
+	Overrides map[string]any `yaml:"overrides,omitempty"`
+
+	// ClientRegistration is advertised as 'registration_endpoint' when enabled
+	ClientRegistration OAuthClientRegistrationConfig `yaml:"client_registration,omitempty"`
+
+	// Proxy replaces the metadata of the issuer with the one of the built-in authorization server when enabled
+	Proxy OAuthProxyConfig `yaml:"proxy,omitempty"`
 }
 
 // OAuthProtectedResourceConfig represents the OAuth Protected Resource configuration
@@ -115,9 +439,81 @@ type BackendTransportConfig struct {
 	Stdio BackendTransportStdioConfig `yaml:"stdio,omitempty"`
 }
 
+// BackendToolResultsCacheRuleConfig represents the TTL applied to results of the tools matching a name pattern
+type BackendToolResultsCacheRuleConfig struct {
+	Tool string        `yaml:"tool"`
+	TTL  time.Duration `yaml:"ttl"`
+}
+
+// BackendToolResultsCacheConfig represents the configuration for memoizing results of read-only tools
+type BackendToolResultsCacheConfig struct {
+	Enabled    bool                                `yaml:"enabled"`
+	DefaultTTL time.Duration                       `yaml:"default_ttl,omitempty"`
+	PerUser    bool                                `yaml:"per_user,omitempty"`
+	Rules      []BackendToolResultsCacheRuleConfig `yaml:"rules,omitempty"`
+
+	// Limits of the memoized results. Least recently used ones are evicted beyond them
+	MaxEntries int `yaml:"max_entries,omitempty"`
+	MaxBytes   int `yaml:"max_bytes,omitempty"`
+}
+
+// BackendCallTimeoutRuleConfig represents the timeout applied to calls of the tools matching a name pattern
+type BackendCallTimeoutRuleConfig struct {
+	Tool    string        `yaml:"tool"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// BackendCallRetryConfig represents the retry policy for transport errors on idempotent tools
+type BackendCallRetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts,omitempty"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// BackendCircuitBreakerConfig represents the circuit breaker configuration for the backend
+type BackendCircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold,omitempty"`
+	OpenDuration     time.Duration `yaml:"open_duration,omitempty"`
+}
+
+// BackendCallsConfig represents the configuration for the tool calls sent to the backend
+type BackendCallsConfig struct {
+	// Timeout is a pointer, as zero disables it and the default is only used when unset
+	Timeout        *time.Duration                 `yaml:"timeout,omitempty"`
+	TimeoutRules   []BackendCallTimeoutRuleConfig `yaml:"timeout_rules,omitempty"`
+	Retry          BackendCallRetryConfig         `yaml:"retry,omitempty"`
+	CircuitBreaker BackendCircuitBreakerConfig    `yaml:"circuit_breaker,omitempty"`
+}
+
+// BackendLoggingConfig represents the configuration for the logs sent by the backend
+type BackendLoggingConfig struct {
+	Level string `yaml:"level,omitempty"`
+}
+
+// BackendCapabilitiesConfig represents the client capabilities advertised to the backend.
+// Related requests are forwarded to the frontend client that originated the call.
+// Only HTTP backends relate them to that call, so the ones of stdio backends are rejected
+type BackendCapabilitiesConfig struct {
+	Sampling    bool `yaml:"sampling"`
+	Elicitation bool `yaml:"elicitation"`
+	Roots       bool `yaml:"roots"`
+}
+
+// BackendRootConfig represents a static root exposed to the backend instead of the clients' ones
+type BackendRootConfig struct {
+	URI  string `yaml:"uri"`
+	Name string `yaml:"name,omitempty"`
+}
+
 // BackendConfig represents the backend configuration section
 type BackendConfig struct {
-	Transport BackendTransportConfig `yaml:"transport,omitempty"`
+	Transport        BackendTransportConfig        `yaml:"transport,omitempty"`
+	Capabilities     BackendCapabilitiesConfig     `yaml:"capabilities,omitempty"`
+	Roots            []BackendRootConfig           `yaml:"roots,omitempty"`
+	Logging          BackendLoggingConfig          `yaml:"logging,omitempty"`
+	ToolResultsCache BackendToolResultsCacheConfig `yaml:"tool_results_cache,omitempty"`
+	Calls            BackendCallsConfig            `yaml:"calls,omitempty"`
 }
 
 // Configuration represents the complete configuration structure
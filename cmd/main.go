@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	//
+	"mcp-proxy/api"
+	"mcp-proxy/internal/certificates"
 	"mcp-proxy/internal/globals"
 	"mcp-proxy/internal/handlers"
+	"mcp-proxy/internal/metrics"
 	"mcp-proxy/internal/middlewares"
 	"mcp-proxy/internal/proxy"
 	"mcp-proxy/internal/tools"
+	"mcp-proxy/internal/tracing"
 
 	//
 	"github.com/mark3labs/mcp-go/server"
@@ -24,43 +37,147 @@ func main() {
 		log.Fatalf("failed creating application context: %v", err.Error())
 	}
 
-	// 1. Initialize middlewares that need it
+	shutdownTracing, err := tracing.Setup(appCtx)
+	if err != nil {
+		log.Fatalf("failed setting up tracing: %v", err.Error())
+	}
+	defer func() {
+		_ = shutdownTracing(context.WithoutCancel(appCtx.Context))
+	}()
+
+	// 1. Create the proxy
+	pxy := proxy.NewMCPProxy(proxy.MCPProxyDependencies{
+		AppContext: appCtx,
+	})
+
+	// 2. Initialize extra handlers for later usage.
+	// The built-in authorization server is needed by the JWT validation middleware
+	hm, err := handlers.NewHandlersManager(handlers.HandlersManagerDependencies{
+		AppCtx: appCtx,
+	})
+	if err != nil {
+		log.Fatalf("failed creating handlers: %v", err.Error())
+	}
+
+	var extraIssuers []api.JWTValidationIssuerConfig
+	if oauthProxyIssuer, found := hm.OAuthProxyIssuer(); found {
+		extraIssuers = append(extraIssuers, oauthProxyIssuer)
+	}
+
+	// 3. Initialize middlewares that need it
 	accessLogsMw := middlewares.NewAccessLogsMiddleware(middlewares.AccessLogsMiddlewareDependencies{
 		AppCtx: appCtx,
 	})
 
-	corsMw := middlewares.NewCORSMiddleware(middlewares.CORSMiddlewareDependencies{
+	metricsMw := middlewares.NewMetricsMiddleware(middlewares.MetricsMiddlewareDependencies{
 		AppCtx: appCtx,
 	})
 
-	jwtValidationMw, err := middlewares.NewJWTValidationMiddleware(middlewares.JWTValidationMiddlewareDependencies{
+	tracingMw := middlewares.NewTracingMiddleware(middlewares.TracingMiddlewareDependencies{
 		AppCtx: appCtx,
 	})
-	if err != nil {
-		appCtx.Logger.Info("failed starting JWT validation middleware", "error", err.Error())
-	}
 
-	// 2. Create the proxy
-	pxy := proxy.NewMCPProxy(proxy.MCPProxyDependencies{
-		AppContext: appCtx,
+	corsMw := middlewares.NewCORSMiddleware(middlewares.CORSMiddlewareDependencies{
+		AppCtx: appCtx,
 	})
 
-	// 3. Create the MCP server and client
-	err = pxy.InitializeBackend(appCtx.Context)
+	// Failures found while starting are handled by the startup policy, once every dependency was tried
+	startupPolicy := appCtx.Config.Server.Options.StartupPolicy
+	if startupPolicy != "fail" && startupPolicy != "lazy" && startupPolicy != "degraded" {
+		log.Fatalf("unsupported startup policy: %s", startupPolicy)
+	}
+
+	var startupErrs []error
+
+	// Requests are never served unauthenticated. When the middleware can not be created,
+	// they are rejected while the proxy is kept running, as the policy asks
+	authMiddleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			http.Error(rw, "Authentication is not available", http.StatusServiceUnavailable)
+		})
+	}
+
+	jwtValidationMw, err := middlewares.NewJWTValidationMiddleware(middlewares.JWTValidationMiddlewareDependencies{
+		AppCtx:         appCtx,
+		GetBackendTool: pxy.GetBackendTool,
+		ExtraIssuers:   extraIssuers,
+	})
 	if err != nil {
-		// TODO
+		jwtValidationErr := fmt.Errorf("failed starting JWT validation middleware: %w", err)
+		startupErrs = append(startupErrs, jwtValidationErr)
+		hm.AddReadinessCheck("jwt_validation", func(ctx context.Context) error {
+			return jwtValidationErr
+		})
+	} else {
+		authMiddleware = jwtValidationMw.Middleware
+	}
+
+	// 4. Create the MCP server and client.
+	// Backend is connected on startup unless the startup policy defers it to the first use
+	if startupPolicy != "lazy" || appCtx.Check {
+		if err = pxy.InitializeBackend(appCtx.Context); err != nil {
+			startupErrs = append(startupErrs, fmt.Errorf("failed connecting to backend: %w", err))
+		}
 	}
 
+	hooks := &server.Hooks{}
+	pxy.RegisterHooks(hooks)
+
 	pxy.McpServer = server.NewMCPServer(
 		appCtx.Config.Server.Name,
 		appCtx.Config.Server.Version,
 		server.WithToolCapabilities(true),
+		server.WithLogging(),
+		server.WithCompletions(),
+		server.WithPromptCompletionProvider(pxy),
+		server.WithResourceCompletionProvider(pxy),
+		server.WithHooks(hooks),
+		server.WithToolHandlerMiddleware(pxy.ToolHandlerMiddleware),
 	)
+	pxy.RegisterNotificationHandlers()
 
-	// 4. Initialize extra handlers for later usage
-	hm := handlers.NewHandlersManager(handlers.HandlersManagerDependencies{
-		AppCtx: appCtx,
-	})
+	// Readiness of the proxy depends on the backend and the keys to validate tokens.
+	// Connection is retried by the check, so the proxy recovers when the backend starts after it.
+	// Lazy backends are only connected on first use, so they can not block the traffic that would do it
+	if startupPolicy != "lazy" || appCtx.Check {
+		hm.AddReadinessCheck("backend", pxy.CheckBackend)
+	}
+
+	if jwtValidationMw != nil && appCtx.Config.Middleware.JWT.Enabled &&
+		appCtx.Config.Middleware.JWT.Validation.Strategy == "local" {
+		hm.AddReadinessCheck("jwks", func(ctx context.Context) error {
+			return jwtValidationMw.CheckJWKS()
+		})
+
+		// First keys were loaded when the middleware was created
+		if err = jwtValidationMw.CheckJWKS(); err != nil {
+			startupErrs = append(startupErrs, err)
+		}
+	}
+
+	// Check mode reports the readiness of every dependency, exiting with an error when any is not ready
+	if appCtx.Check {
+		healthResponse := hm.RunReadinessChecks(appCtx.Context)
+		healthResponseBytes, _ := json.MarshalIndent(healthResponse, "", "  ")
+		fmt.Println(string(healthResponseBytes))
+
+		_ = pxy.CloseBackend()
+
+		// Deferred functions do not run on exit, so pending spans are flushed here
+		_ = shutdownTracing(context.WithoutCancel(appCtx.Context))
+		if healthResponse.Status != "ready" {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	for _, startupErr := range startupErrs {
+		if startupPolicy == "fail" {
+			log.Fatalf("%v (startup policy: %s)", startupErr.Error(), startupPolicy)
+		}
+		appCtx.Logger.Error(fmt.Sprintf("serving in %s mode until dependencies are reachable", startupPolicy),
+			"error", startupErr.Error(), "startup_policy", startupPolicy)
+	}
 
 	// 5. Add some useful magic in the form of tools to your MCP server
 	// This is the most useful part
@@ -70,7 +187,35 @@ func main() {
 	})
 	tm.AddTools()
 
+	// Metrics are only served by a dedicated listener, as they are not authenticated.
+	// It is reachable with any transport, and usually kept out of the public network
+	metrics.RegisterCaches(pxy.Cache, pxy.ResultsCache)
+
+	var metricsServer *http.Server
+	if appCtx.Config.Server.Metrics.Enabled {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:    appCtx.Config.Server.Metrics.Host,
+			Handler: metricsMux,
+		}
+
+		go func() {
+			appCtx.Logger.Info("starting metrics server", "host", appCtx.Config.Server.Metrics.Host)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	// 6. Wrap MCP server in a transport (stdio, HTTP, SSE)
+	// Servers run until the process is asked to stop, then the in-flight work is drained
+	signalCtx, stopSignals := signal.NotifyContext(appCtx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	var shutdownCtx context.Context
+	var cancelShutdown context.CancelFunc
+
 	switch appCtx.Config.Server.Transport.Type {
 	case "http":
 		httpServer := server.NewStreamableHTTPServer(pxy.McpServer,
@@ -81,28 +226,139 @@ func main() {
 		// Custom endpoints are needed as the library is not feature-complete according to MCP spec requirements (2025-06-16)
 		// Ref: https://modelcontextprotocol.io/specification/2025-06-18/basic/authorization#overview
 		mux := http.NewServeMux()
-		mux.Handle("/mcp", accessLogsMw.Middleware(corsMw.Middleware(jwtValidationMw.Middleware(httpServer))))
+		mux.Handle("/mcp", metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(authMiddleware(httpServer))))))
 
 		if appCtx.Config.OAuthAuthorizationServer.Enabled {
-			mux.Handle("/.well-known/oauth-authorization-server", accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthAuthorizationServer))))
+			mux.Handle("/.well-known/oauth-authorization-server", metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthAuthorizationServer))))))
+
+			if appCtx.Config.OAuthAuthorizationServer.ClientRegistration.Enabled {
+				mux.Handle(handlers.ClientRegistrationPath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthClientRegistration))))))
+			}
+
+			if appCtx.Config.OAuthAuthorizationServer.Proxy.Enabled {
+				mux.Handle(handlers.OAuthProxyAuthorizePath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(http.HandlerFunc(hm.HandleOauthProxyAuthorize)))))
+				mux.Handle(handlers.OAuthProxyCallbackPath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(http.HandlerFunc(hm.HandleOauthProxyCallback)))))
+				mux.Handle(handlers.OAuthProxyConsentPath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(http.HandlerFunc(hm.HandleOauthProxyConsent)))))
+				mux.Handle(handlers.OAuthProxyTokenPath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthProxyToken))))))
+				mux.Handle(handlers.OAuthProxyJWKSPath, metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthProxyJWKS))))))
+			}
 		}
 
 		if appCtx.Config.OAuthProtectedResource.Enabled {
-			mux.Handle("/.well-known/oauth-protected-resource", accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthProtectedResources))))
+			mux.Handle("/.well-known/oauth-protected-resource", metricsMw.Middleware(tracingMw.Middleware(accessLogsMw.Middleware(corsMw.Middleware(http.HandlerFunc(hm.HandleOauthProtectedResources))))))
+		}
+
+		// Health endpoints are polled often, so they are not logged
+		mux.HandleFunc(handlers.HealthzPath, hm.HandleHealthz)
+		mux.HandleFunc(handlers.LivezPath, hm.HandleHealthz)
+		mux.HandleFunc(handlers.ReadyzPath, hm.HandleReadyz)
+
+		// Requests are bound to their own context, so the streams still open can be closed on shutdown
+		requestsCtx, cancelRequests := context.WithCancel(context.WithoutCancel(appCtx.Context))
+		defer cancelRequests()
+
+		httpListener := &http.Server{
+			Addr:    appCtx.Config.Server.Transport.HTTP.Host,
+			Handler: mux,
+			BaseContext: func(net.Listener) context.Context {
+				return requestsCtx
+			},
 		}
 
 		// Start StreamableHTTP server
-		appCtx.Logger.Info("starting StreamableHTTP server", "host", appCtx.Config.Server.Transport.HTTP.Host)
-		err := http.ListenAndServe(appCtx.Config.Server.Transport.HTTP.Host, mux)
-		if err != nil {
+		appCtx.Logger.Info("starting StreamableHTTP server", "host", appCtx.Config.Server.Transport.HTTP.Host,
+			"tls", appCtx.Config.Server.Transport.HTTP.TLS.Enabled)
+
+		if appCtx.Config.Server.Transport.HTTP.TLS.Enabled {
+			certificatesReloader, err := certificates.NewCertificatesReloader(certificates.CertificatesReloaderDependencies{
+				AppCtx: appCtx,
+			})
+			if err != nil {
+				log.Fatalf("failed loading TLS certificates: %v", err.Error())
+			}
+			certificatesReloader.Run(appCtx.Context)
+
+			httpListener.TLSConfig = certificatesReloader.TLSConfig()
+		}
+
+		serveErr := make(chan error, 1)
+		go func() {
+			if httpListener.TLSConfig != nil {
+				serveErr <- httpListener.ListenAndServeTLS("", "")
+				return
+			}
+			serveErr <- httpListener.ListenAndServe()
+		}()
+
+		select {
+		case err = <-serveErr:
 			log.Fatal(err)
+		case <-signalCtx.Done():
+		}
+
+		// New connections are refused while in-flight calls finish.
+		// Remaining requests are streams waiting for messages, so they are closed then
+		appCtx.Logger.Info("shutting down StreamableHTTP server", "timeout", appCtx.Config.Server.Options.ShutdownTimeout.String())
+		shutdownCtx, cancelShutdown = context.WithTimeout(context.WithoutCancel(appCtx.Context), appCtx.Config.Server.Options.ShutdownTimeout)
+		defer cancelShutdown()
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- httpListener.Shutdown(shutdownCtx)
+		}()
+
+		pxy.DrainCalls(shutdownCtx)
+		cancelRequests()
+
+		if err = <-shutdownErr; err != nil {
+			appCtx.Logger.Warn("failed shutting down StreamableHTTP server gracefully", "error", err.Error())
+			_ = httpListener.Close()
 		}
 
 	default:
+		// Stdin is read through a pipe, so reading can stop on shutdown while in-flight calls are still answered
+		stdinReader, stdinWriter := io.Pipe()
+		go func() {
+			_, _ = io.Copy(stdinWriter, os.Stdin)
+			_ = stdinWriter.Close()
+		}()
+
 		// Start stdio server
 		appCtx.Logger.Info("starting stdio server")
-		if err := server.ServeStdio(pxy.McpServer); err != nil {
-			log.Fatal(err)
+		listenErr := make(chan error, 1)
+		go func() {
+			listenErr <- server.NewStdioServer(pxy.McpServer).Listen(appCtx.Context, stdinReader, os.Stdout)
+		}()
+
+		select {
+		case err = <-listenErr:
+			if err != nil {
+				log.Fatal(err)
+			}
+		case <-signalCtx.Done():
+			_ = stdinWriter.Close()
+		}
+
+		appCtx.Logger.Info("shutting down stdio server", "timeout", appCtx.Config.Server.Options.ShutdownTimeout.String())
+		shutdownCtx, cancelShutdown = context.WithTimeout(context.WithoutCancel(appCtx.Context), appCtx.Config.Server.Options.ShutdownTimeout)
+		defer cancelShutdown()
+
+		pxy.DrainCalls(shutdownCtx)
+	}
+
+	// Metrics are kept until the end, so the drain can be observed
+	if metricsServer != nil {
+		if err = metricsServer.Shutdown(shutdownCtx); err != nil {
+			appCtx.Logger.Warn("failed shutting down metrics server gracefully", "error", err.Error())
+			_ = metricsServer.Close()
 		}
 	}
+
+	// 7. Release the backend and stop the background workers
+	if err = pxy.CloseBackend(); err != nil {
+		appCtx.Logger.Warn("failed closing backend connection", "error", err.Error())
+	}
+	appCtx.Cancel()
+
+	appCtx.Logger.Info("shutdown completed")
 }
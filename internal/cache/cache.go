@@ -6,6 +6,9 @@ import "sync"
 type CacheEntry struct {
 	Data      interface{}
 	Timestamp int64
+
+	// Bytes is the size of the JSON encoded data
+	Bytes int
 }
 
 type Cache struct {
@@ -19,3 +22,14 @@ func NewCache() *Cache {
 		Registry: cacheRegistry,
 	}
 }
+
+// Stats returns the number of entries and their size in bytes
+func (c *Cache) Stats() (entries int, bytes int) {
+	c.Mu.RLock()
+	defer c.Mu.RUnlock()
+
+	for _, entry := range c.Registry {
+		bytes += entry.Bytes
+	}
+	return len(c.Registry), bytes
+}
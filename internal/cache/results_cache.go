@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ResultsCacheEntry represents a memoized tool result that is valid until its expiration.
+// Results are kept JSON encoded, so every hit gets its own copy and callers can not alter them
+type ResultsCacheEntry struct {
+	Key       string
+	Result    []byte
+	ExpiresAt time.Time
+}
+
+// ResultsCache stores results of read-only tools to avoid hitting slow backends
+// with repeated calls. It is not related to the big responses Cache used by 'read_cache'.
+// Least recently used entries are evicted when it reaches its limits
+type ResultsCache struct {
+	Mu       sync.Mutex
+	Registry map[string]*list.Element // key --> *ResultsCacheEntry, in recency order
+	recency  *list.List
+
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	lastPrune  time.Time
+}
+
+// NewResultsCache creates a cache holding up to 'maxEntries' results, and 'maxBytes' of them.
+// Limits below one are not enforced
+func NewResultsCache(maxEntries, maxBytes int) *ResultsCache {
+	return &ResultsCache{
+		Registry:   map[string]*list.Element{},
+		recency:    list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lastPrune:  time.Now(),
+	}
+}
+
+// GenerateResultsCacheKey returns a deterministic key for a tool call.
+// Arguments are canonicalized by JSON encoding, which sorts map keys at every level
+func GenerateResultsCacheKey(toolName string, args map[string]interface{}, user string) (string, error) {
+	argsBytes, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(toolName))
+	hash.Write([]byte{0})
+	hash.Write([]byte(user))
+	hash.Write([]byte{0})
+	hash.Write(argsBytes)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Get returns a copy of the memoized result for a key when it exists and is not expired
+func (c *ResultsCache) Get(key string) (*mcp.CallToolResult, bool) {
+	c.Mu.Lock()
+	element, exists := c.Registry[key]
+	if !exists {
+		c.Mu.Unlock()
+		return nil, false
+	}
+
+	entry := element.Value.(*ResultsCacheEntry)
+	if time.Now().After(entry.ExpiresAt) {
+		c.remove(element)
+		c.Mu.Unlock()
+		return nil, false
+	}
+	c.recency.MoveToFront(element)
+	c.Mu.Unlock()
+
+	result := &mcp.CallToolResult{}
+	if err := json.Unmarshal(entry.Result, result); err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// Set memoizes a copy of a result for the given TTL, evicting the least recently used results beyond the limits.
+// Expired entries are pruned from time to time
+func (c *ResultsCache) Set(key string, result *mcp.CallToolResult, ttl time.Duration) {
+	now := time.Now()
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	if element, exists := c.Registry[key]; exists {
+		c.remove(element)
+	}
+
+	// Results bigger than the whole cache would evict everything else
+	if c.maxBytes > 0 && len(resultBytes) > c.maxBytes {
+		return
+	}
+
+	c.Registry[key] = c.recency.PushFront(&ResultsCacheEntry{
+		Key:       key,
+		Result:    resultBytes,
+		ExpiresAt: now.Add(ttl),
+	})
+	c.bytes += len(resultBytes)
+
+	for (c.maxEntries > 0 && len(c.Registry) > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.recency.Back())
+	}
+
+	// Don't walk the whole registry on every write
+	if now.Sub(c.lastPrune) < time.Minute {
+		return
+	}
+
+	for _, element := range c.Registry {
+		if now.After(element.Value.(*ResultsCacheEntry).ExpiresAt) {
+			c.remove(element)
+		}
+	}
+	c.lastPrune = now
+}
+
+// remove deletes an entry. Caller must hold the lock
+func (c *ResultsCache) remove(element *list.Element) {
+	entry := c.recency.Remove(element).(*ResultsCacheEntry)
+	delete(c.Registry, entry.Key)
+	c.bytes -= len(entry.Result)
+}
+
+// Stats returns the number of entries and their size in bytes. Expired entries not pruned yet are included
+func (c *ResultsCache) Stats() (entries int, bytes int) {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	return len(c.Registry), c.bytes
+}
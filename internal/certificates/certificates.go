@@ -0,0 +1,172 @@
+package certificates
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	//
+	"mcp-proxy/internal/globals"
+)
+
+type CertificatesReloaderDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+// CertificatesReloader keeps the TLS server certificate and the client CA bundle up-to-date.
+// Last loaded ones are kept when reloading fails, so a half-written file does not break the listener
+type CertificatesReloader struct {
+	dependencies CertificatesReloaderDependencies
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+
+	// Modification times of the files when they were loaded
+	modTimes map[string]time.Time
+}
+
+// NewCertificatesReloader loads the certificates, failing when they are not valid
+func NewCertificatesReloader(deps CertificatesReloaderDependencies) (*CertificatesReloader, error) {
+	cr := &CertificatesReloader{
+		dependencies: deps,
+		modTimes:     map[string]time.Time{},
+	}
+
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Run checks the files for changes from time to time, until the context is done
+func (cr *CertificatesReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(cr.dependencies.AppCtx.Config.Server.Transport.HTTP.TLS.ReloadInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !cr.filesChanged() {
+					continue
+				}
+
+				if err := cr.reload(); err != nil {
+					cr.dependencies.AppCtx.Logger.Error("failed reloading TLS certificates, keeping the loaded ones", "error", err.Error())
+					continue
+				}
+				cr.dependencies.AppCtx.Logger.Info("TLS certificates reloaded")
+			}
+		}
+	}()
+}
+
+// TLSConfig returns the TLS configuration for the HTTP server.
+// It is resolved on every handshake, so reloaded certificates are used by new connections
+func (cr *CertificatesReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mutex.RLock()
+			defer cr.mutex.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cr.certificate},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+
+			if cr.clientCAs != nil {
+				config.ClientCAs = cr.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				if cr.dependencies.AppCtx.Config.Server.Transport.HTTP.TLS.ClientAuth == "request" {
+					config.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+
+			return config, nil
+		},
+	}
+}
+
+// reload loads the certificate, its key and the client CA bundle from their files
+func (cr *CertificatesReloader) reload() error {
+	tlsConfig := cr.dependencies.AppCtx.Config.Server.Transport.HTTP.TLS
+
+	modTimes, err := getModTimes(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed loading TLS certificate: %s", err.Error())
+	}
+
+	var clientCAs *x509.CertPool
+	if tlsConfig.ClientCAFile != "" {
+		caBytes, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed reading client CA bundle: %s", err.Error())
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("client CA bundle has no valid certificates")
+		}
+	}
+
+	cr.mutex.Lock()
+	cr.certificate = &certificate
+	cr.clientCAs = clientCAs
+	cr.modTimes = modTimes
+	cr.mutex.Unlock()
+
+	return nil
+}
+
+// filesChanged checks whether any of the files was modified since it was loaded
+func (cr *CertificatesReloader) filesChanged() bool {
+	tlsConfig := cr.dependencies.AppCtx.Config.Server.Transport.HTTP.TLS
+
+	modTimes, err := getModTimes(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+	if err != nil {
+		cr.dependencies.AppCtx.Logger.Warn("failed checking TLS certificates for changes", "error", err.Error())
+		return false
+	}
+
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(cr.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getModTimes returns the modification times of the files. Empty paths are ignored
+func getModTimes(files ...string) (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		fileInfo, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading TLS file: %s", err.Error())
+		}
+		modTimes[file] = fileInfo.ModTime()
+	}
+	return modTimes, nil
+}
@@ -2,12 +2,28 @@ package config
 
 import (
 	"gopkg.in/yaml.v3"
+	"net"
 	"os"
+	"slices"
 
 	//
 	"mcp-proxy/api"
 )
 
+// isLoopbackEndpoint returns whether a 'host:port' endpoint points to the local machine
+func isLoopbackEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 // replaceDefaults TODO
 func replaceDefaults(config *api.Configuration) {
 
@@ -22,6 +38,135 @@ func replaceDefaults(config *api.Configuration) {
 	if config.Server.Options.PaginationMaxPageSize == 0 {
 		config.Server.Options.PaginationMaxPageSize = api.DefaultPaginationMaxPageSize
 	}
+
+	if config.Server.Options.ShutdownTimeout == 0 {
+		config.Server.Options.ShutdownTimeout = api.DefaultShutdownTimeout
+	}
+
+	if config.Server.Options.StartupPolicy == "" {
+		config.Server.Options.StartupPolicy = api.DefaultStartupPolicy
+	}
+
+	if config.Server.Transport.HTTP.TLS.ReloadInterval == 0 {
+		config.Server.Transport.HTTP.TLS.ReloadInterval = api.DefaultTLSReloadInterval
+	}
+
+	if config.Server.Transport.HTTP.TLS.ClientCAFile != "" && config.Server.Transport.HTTP.TLS.ClientAuth == "" {
+		config.Server.Transport.HTTP.TLS.ClientAuth = "require"
+	}
+
+	if config.Server.Metrics.Host == "" {
+		config.Server.Metrics.Host = api.DefaultMetricsHost
+	}
+
+	if config.Server.Tracing.Exporter == "" {
+		config.Server.Tracing.Exporter = api.DefaultTracingExporter
+	}
+
+	if config.Server.Tracing.OTLP.Endpoint == "" {
+		config.Server.Tracing.OTLP.Endpoint = api.DefaultTracingOTLPEndpoint
+	}
+
+	// Local collectors rarely serve TLS, while remote ones must not receive the traces in plain text
+	if config.Server.Tracing.OTLP.Insecure == nil {
+		insecure := isLoopbackEndpoint(config.Server.Tracing.OTLP.Endpoint)
+		config.Server.Tracing.OTLP.Insecure = &insecure
+	}
+
+	if config.Server.Tracing.SampleRatio == nil {
+		sampleRatio := api.DefaultTracingSampleRatio
+		config.Server.Tracing.SampleRatio = &sampleRatio
+	}
+
+	if config.OAuthAuthorizationServer.CacheTTL == 0 {
+		config.OAuthAuthorizationServer.CacheTTL = api.DefaultAuthorizationServerMetadataCacheTTL
+	}
+
+	if len(config.OAuthAuthorizationServer.ClientRegistration.AllowedGrantTypes) == 0 {
+		config.OAuthAuthorizationServer.ClientRegistration.AllowedGrantTypes = api.DefaultClientRegistrationGrantTypes
+	}
+
+	if config.OAuthAuthorizationServer.Proxy.TokenTTL == 0 {
+		config.OAuthAuthorizationServer.Proxy.TokenTTL = api.DefaultOAuthProxyTokenTTL
+	}
+
+	if len(config.OAuthAuthorizationServer.Proxy.Scopes) == 0 {
+		config.OAuthAuthorizationServer.Proxy.Scopes = api.DefaultOAuthProxyScopes
+	}
+
+	if len(config.OAuthAuthorizationServer.Proxy.ForwardedClaims) == 0 {
+		config.OAuthAuthorizationServer.Proxy.ForwardedClaims = api.DefaultOAuthProxyForwardedClaims
+	}
+
+	if config.Backend.ToolResultsCache.MaxEntries == 0 {
+		config.Backend.ToolResultsCache.MaxEntries = api.DefaultToolResultsCacheMaxEntries
+	}
+
+	if config.Backend.ToolResultsCache.MaxBytes == 0 {
+		config.Backend.ToolResultsCache.MaxBytes = api.DefaultToolResultsCacheMaxBytes
+	}
+
+	if config.Backend.Calls.Timeout == nil {
+		callTimeout := api.DefaultBackendCallTimeout
+		config.Backend.Calls.Timeout = &callTimeout
+	}
+
+	if config.Backend.Calls.Retry.MaxAttempts == 0 {
+		config.Backend.Calls.Retry.MaxAttempts = api.DefaultBackendCallRetryMaxAttempts
+	}
+
+	if config.Backend.Calls.Retry.InitialBackoff == 0 {
+		config.Backend.Calls.Retry.InitialBackoff = api.DefaultBackendCallRetryInitialBackoff
+	}
+
+	if config.Backend.Calls.Retry.MaxBackoff == 0 {
+		config.Backend.Calls.Retry.MaxBackoff = api.DefaultBackendCallRetryMaxBackoff
+	}
+
+	if config.Backend.Calls.CircuitBreaker.FailureThreshold == 0 {
+		config.Backend.Calls.CircuitBreaker.FailureThreshold = api.DefaultBackendCircuitBreakerThreshold
+	}
+
+	if config.Backend.Calls.CircuitBreaker.OpenDuration == 0 {
+		config.Backend.Calls.CircuitBreaker.OpenDuration = api.DefaultBackendCircuitBreakerOpenDuration
+	}
+
+	if config.Middleware.JWT.Validation.Introspection.Timeout == 0 {
+		config.Middleware.JWT.Validation.Introspection.Timeout = api.DefaultJWTIntrospectionTimeout
+	}
+
+	if config.Middleware.JWT.Validation.APIKey.ReloadInterval == 0 {
+		config.Middleware.JWT.Validation.APIKey.ReloadInterval = api.DefaultAPIKeysReloadInterval
+	}
+
+	if config.Middleware.JWT.Validation.Local.CacheInterval == 0 {
+		config.Middleware.JWT.Validation.Local.CacheInterval = api.DefaultJWKSCacheInterval
+	}
+
+	if config.Middleware.JWT.Validation.Local.MinRefreshInterval == 0 {
+		config.Middleware.JWT.Validation.Local.MinRefreshInterval = api.DefaultJWKSMinRefreshInterval
+	}
+
+	if config.Middleware.JWT.DPoP.ProofMaxAge == 0 {
+		config.Middleware.JWT.DPoP.ProofMaxAge = api.DefaultDPoPProofMaxAge
+	}
+
+	// Only missing claims are defaulted, so an explicit empty list disables the requirement
+	if config.Middleware.JWT.Validation.Local.RequiredClaims == nil {
+		config.Middleware.JWT.Validation.Local.RequiredClaims = slices.Clone(api.DefaultJWTRequiredClaims)
+	}
+
+	for i := range config.Middleware.JWT.Validation.Local.Issuers {
+		issuer := &config.Middleware.JWT.Validation.Local.Issuers[i]
+
+		if issuer.CacheInterval == 0 {
+			issuer.CacheInterval = config.Middleware.JWT.Validation.Local.CacheInterval
+		}
+
+		if issuer.MinRefreshInterval == 0 {
+			issuer.MinRefreshInterval = config.Middleware.JWT.Validation.Local.MinRefreshInterval
+		}
+	}
 }
 
 // Marshal TODO
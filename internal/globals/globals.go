@@ -15,19 +15,30 @@ type ApplicationContext struct {
 	Context context.Context
 	Logger  *slog.Logger
 	Config  *api.Configuration
+
+	// Cancel stops the background workers bound to Context, such as the stdio backend process
+	Cancel context.CancelFunc
+
+	// Check is set when the proxy only validates its config and connectivity, then exits
+	Check bool
 }
 
 func NewApplicationContext() (*ApplicationContext, error) {
 
+	ctx, cancel := context.WithCancel(context.Background())
 	appCtx := &ApplicationContext{
-		Context: context.Background(),
+		Context: ctx,
+		Cancel:  cancel,
 		Logger:  slog.New(slog.NewJSONHandler(os.Stderr, nil)),
 	}
 
 	// Parse and store the config
 	var configFlag = flag.String("config", "config.yaml", "path to the config file")
+	var checkFlag = flag.Bool("check", false, "validate the config and the connectivity with the backend and the identity providers, then exit")
 	flag.Parse()
 
+	appCtx.Check = *checkFlag
+
 	configContent, err := config.ReadFile(*configFlag)
 	if err != nil {
 		return appCtx, err
@@ -1,6 +1,13 @@
 package handlers
 
-import "mcp-proxy/internal/globals"
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	//
+	"mcp-proxy/internal/globals"
+)
 
 type HandlersManagerDependencies struct {
 	AppCtx *globals.ApplicationContext
@@ -8,10 +15,47 @@ type HandlersManagerDependencies struct {
 
 type HandlersManager struct {
 	dependencies HandlersManagerDependencies
+
+	// Carried stuff
+	httpClient                  *http.Client
+	authorizationServerMetadata *metadataCache
+	oauthProxy                  *oauthProxy
+	readinessChecks             []namedReadinessCheck
 }
 
-func NewHandlersManager(deps HandlersManagerDependencies) *HandlersManager {
-	return &HandlersManager{
-		dependencies: deps,
+func NewHandlersManager(deps HandlersManagerDependencies) (*HandlersManager, error) {
+	hm := &HandlersManager{
+		dependencies:                deps,
+		httpClient:                  &http.Client{Timeout: 10 * time.Second},
+		authorizationServerMetadata: &metadataCache{},
 	}
+
+	// The built-in authorization server needs its signing key before tokens are validated
+	if deps.AppCtx.Config.OAuthAuthorizationServer.Enabled && deps.AppCtx.Config.OAuthAuthorizationServer.Proxy.Enabled {
+		// Issued tokens are only trusted by the local validation, and their audience is the protected resource
+		jwtConfig := deps.AppCtx.Config.Middleware.JWT
+		if !jwtConfig.Enabled || jwtConfig.Validation.Strategy != "local" {
+			return nil, fmt.Errorf("built-in authorization server requires JWT validation with 'local' strategy")
+		}
+
+		if deps.AppCtx.Config.OAuthProtectedResource.Resource == "" {
+			return nil, fmt.Errorf("built-in authorization server requires 'oauth_protected_resource.resource'")
+		}
+
+		if hm.getOAuthProxyIssuer() == "" {
+			return nil, fmt.Errorf("built-in authorization server issuer can not be determined, set 'issuer' or the protected resource")
+		}
+
+		var err error
+		hm.oauthProxy, err = newOAuthProxy(deps.AppCtx.Config.OAuthAuthorizationServer.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("failed starting built-in authorization server: %s", err.Error())
+		}
+
+		if deps.AppCtx.Config.OAuthAuthorizationServer.Proxy.SigningKeyFile == "" {
+			deps.AppCtx.Logger.Warn("built-in authorization server uses an ephemeral signing key, tokens will not be valid after restarting")
+		}
+	}
+
+	return hm, nil
 }
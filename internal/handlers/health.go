@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Paths where the health endpoints are served
+	HealthzPath = "/healthz"
+	LivezPath   = "/livez"
+	ReadyzPath  = "/readyz"
+
+	// readinessCheckTimeout limits how long every readiness check can take
+	readinessCheckTimeout = 5 * time.Second
+)
+
+// ReadinessCheck reports whether a component is ready to serve requests
+type ReadinessCheck func(ctx context.Context) error
+
+type namedReadinessCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// HealthCheckResult represents the result of a single check in the response of the health endpoints
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthResponse represents the response returned by the health endpoints
+type HealthResponse struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+// AddReadinessCheck registers a check that must pass for the proxy to be ready
+func (h *HandlersManager) AddReadinessCheck(name string, check ReadinessCheck) {
+	h.readinessChecks = append(h.readinessChecks, namedReadinessCheck{name: name, check: check})
+}
+
+// HandleHealthz process requests for endpoints: /healthz and /livez
+// It only reports the process is up and serving
+func (h *HandlersManager) HandleHealthz(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeHealthResponse(response, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// HandleReadyz process requests for endpoint: /readyz
+func (h *HandlersManager) HandleReadyz(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response.Header().Set("Allow", http.MethodGet+", "+http.MethodHead)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	healthResponse := h.RunReadinessChecks(request.Context())
+
+	statusCode := http.StatusOK
+	if healthResponse.Status != "ready" {
+		statusCode = http.StatusServiceUnavailable
+		h.dependencies.AppCtx.Logger.Debug("readiness checks failed", "checks", healthResponse.Checks)
+	}
+
+	h.writeHealthResponse(response, statusCode, healthResponse)
+}
+
+// RunReadinessChecks runs every readiness check concurrently. The proxy is ready only when all of them pass
+func (h *HandlersManager) RunReadinessChecks(ctx context.Context) HealthResponse {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	results := make([]HealthCheckResult, len(h.readinessChecks))
+	wg := sync.WaitGroup{}
+	for i, readinessCheck := range h.readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i] = HealthCheckResult{Status: "ok"}
+			if err := readinessCheck.check(ctx); err != nil {
+				results[i] = HealthCheckResult{Status: "error", Error: err.Error()}
+			}
+		}()
+	}
+	wg.Wait()
+
+	healthResponse := HealthResponse{
+		Status: "ready",
+		Checks: make(map[string]HealthCheckResult, len(results)),
+	}
+
+	for i, result := range results {
+		healthResponse.Checks[h.readinessChecks[i].name] = result
+		if result.Status != "ok" {
+			healthResponse.Status = "not_ready"
+		}
+	}
+
+	return healthResponse
+}
+
+// writeHealthResponse sends the response of a health endpoint. It must never be cached
+func (h *HandlersManager) writeHealthResponse(response http.ResponseWriter, statusCode int, healthResponse HealthResponse) {
+	healthResponseBytes, err := json.Marshal(healthResponse)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error converting response into json", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(statusCode)
+
+	_, err = response.Write(healthResponseBytes)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
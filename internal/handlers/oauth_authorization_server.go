@@ -1,12 +1,30 @@
 package handlers
 
 import (
-	"io"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	//
+	"mcp-proxy/internal/middlewares"
 )
 
+// metadataCache keeps the last metadata document fetched from the authorization server
+type metadataCache struct {
+	mutex     sync.Mutex
+	metadata  map[string]any
+	expiresAt time.Time
+}
+
 // HandleOauthAuthorizationServer process requests for endpoint: /.well-known/oauth-authorization-server
+// Metadata of the issuer is cached, or replaced by the one of the built-in authorization server.
+// Configured overrides are applied over it
 func (h *HandlersManager) HandleOauthAuthorizationServer(response http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		response.Header().Set("Allow", http.MethodGet)
@@ -14,35 +32,139 @@ func (h *HandlersManager) HandleOauthAuthorizationServer(response http.ResponseW
 		return
 	}
 
-	remoteUrl := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.IssuerUri + "/.well-known/openid-configuration"
-	client := &http.Client{Timeout: 10 * time.Second}
-	remoteResponse, err := client.Get(remoteUrl)
-	if err != nil {
-		h.dependencies.AppCtx.Logger.Error("error getting content from /.well-known/openid-configuration", "error", err.Error())
-		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
-		return
+	// The built-in authorization server stands in front of the issuer, so clients only talk to it
+	var metadata map[string]any
+	var err error
+	if h.oauthProxy != nil {
+		metadata = h.getOAuthProxyMetadata()
+	} else {
+		metadata, err = h.getAuthorizationServerMetadata()
+		if err != nil {
+			h.dependencies.AppCtx.Logger.Error("error getting authorization server metadata", "error", err.Error())
+			http.Error(response, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
 	}
-	defer remoteResponse.Body.Close()
 
-	if remoteResponse.StatusCode != http.StatusOK {
-		h.dependencies.AppCtx.Logger.Error("unexpected status code from /.well-known/openid-configuration", "status", remoteResponse.Status)
-		http.Error(response, "Bad Gateway", http.StatusBadGateway)
-		return
+	// Advertise the client registration facade. Overrides can still replace it
+	if h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.Enabled {
+		metadata["registration_endpoint"] = middlewares.GetPublicOrigin(h.dependencies.AppCtx.Config, request) + ClientRegistrationPath
 	}
 
-	remoteResponseBytes, err := io.ReadAll(remoteResponse.Body)
+	for field, value := range h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Overrides {
+		if value == nil {
+			delete(metadata, field)
+			continue
+		}
+		metadata[field] = value
+	}
+
+	// Transform into JSON
+	metadataBytes, err := json.Marshal(metadata)
 	if err != nil {
-		h.dependencies.AppCtx.Logger.Error("error reading bytes from remote response", "error", err.Error())
+		h.dependencies.AppCtx.Logger.Error("error converting response into json", "error", err.Error())
 		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	cacheTTL := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.CacheTTL
 	response.Header().Set("Content-Type", "application/json")
-	response.Header().Set("Cache-Control", "max-age=3600")
+	response.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(cacheTTL.Seconds())))
 
-	_, err = response.Write(remoteResponseBytes)
+	_, err = response.Write(metadataBytes)
 	if err != nil {
 		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
 		return
 	}
 }
+
+// getAuthorizationServerMetadata returns a copy of the metadata of the issuer, fetching it when the cache expired.
+// Stale metadata is returned when the issuer is not reachable, as it rarely changes
+func (h *HandlersManager) getAuthorizationServerMetadata() (map[string]any, error) {
+	cache := h.authorizationServerMetadata
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.metadata != nil && time.Now().Before(cache.expiresAt) {
+		return maps.Clone(cache.metadata), nil
+	}
+
+	metadata, err := h.fetchAuthorizationServerMetadata()
+	if err != nil {
+		if cache.metadata == nil {
+			return nil, err
+		}
+		h.dependencies.AppCtx.Logger.Warn("failed refreshing authorization server metadata, serving cached one", "error", err.Error())
+		return maps.Clone(cache.metadata), nil
+	}
+
+	cache.metadata = metadata
+	cache.expiresAt = time.Now().Add(h.dependencies.AppCtx.Config.OAuthAuthorizationServer.CacheTTL)
+
+	return maps.Clone(cache.metadata), nil
+}
+
+// fetchAuthorizationServerMetadata gets the metadata of the issuer from the first well-known location serving it
+func (h *HandlersManager) fetchAuthorizationServerMetadata() (map[string]any, error) {
+	metadataUris, err := getAuthorizationServerMetadataUris(h.dependencies.AppCtx.Config.OAuthAuthorizationServer.IssuerUri)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	for _, metadataUri := range metadataUris {
+		metadata, err := h.fetchMetadata(metadataUri)
+		if err == nil {
+			return metadata, nil
+		}
+
+		h.dependencies.AppCtx.Logger.Debug("authorization server metadata not available", "uri", metadataUri, "error", err.Error())
+		errs = append(errs, err.Error())
+	}
+
+	return nil, fmt.Errorf("no well-known location served the metadata: %s", strings.Join(errs, "; "))
+}
+
+// fetchMetadata gets a metadata document from a URI
+func (h *HandlersManager) fetchMetadata(metadataUri string) (map[string]any, error) {
+	remoteResponse, err := h.httpClient.Get(metadataUri)
+	if err != nil {
+		return nil, fmt.Errorf("error getting content from %s: %s", metadataUri, err.Error())
+	}
+	defer remoteResponse.Body.Close()
+
+	if remoteResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from %s: %s", metadataUri, remoteResponse.Status)
+	}
+
+	var metadata map[string]any
+	if err := json.NewDecoder(remoteResponse.Body).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding content from %s: %s", metadataUri, err.Error())
+	}
+
+	return metadata, nil
+}
+
+// getAuthorizationServerMetadataUris returns the well-known locations of the metadata of an issuer, in order of preference.
+// OIDC appends the well-known path to the issuer, while RFC 8414 inserts it between the host and the issuer path
+// Ref: https://datatracker.ietf.org/doc/html/rfc8414#section-3.1
+func getAuthorizationServerMetadataUris(issuerUri string) ([]string, error) {
+	issuerUrl, err := url.Parse(strings.TrimRight(issuerUri, "/"))
+	if err != nil || issuerUrl.Scheme == "" || issuerUrl.Host == "" {
+		return nil, fmt.Errorf("invalid issuer URI: %s", issuerUri)
+	}
+
+	origin := issuerUrl.Scheme + "://" + issuerUrl.Host
+	metadataUris := []string{
+		origin + issuerUrl.Path + "/.well-known/openid-configuration",
+		origin + "/.well-known/oauth-authorization-server" + issuerUrl.Path,
+	}
+
+	// Some servers append the RFC 8414 path like OIDC does
+	if issuerUrl.Path != "" {
+		metadataUris = append(metadataUris, origin+issuerUrl.Path+"/.well-known/oauth-authorization-server")
+	}
+
+	return metadataUris, nil
+}
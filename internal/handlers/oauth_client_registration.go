@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Error codes of the client registration endpoint
+	// Ref: https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.2
+	RegistrationErrorInvalidRedirectUri    = "invalid_redirect_uri"
+	RegistrationErrorInvalidClientMetadata = "invalid_client_metadata"
+
+	// ClientRegistrationPath is the path where the client registration facade is served
+	ClientRegistrationPath = "/register"
+
+	// maxRegistrationBodyBytes limits the size of registration requests
+	maxRegistrationBodyBytes = 64 * 1024
+)
+
+// ClientRegistrationRequest represents the client metadata sent to '/register' endpoint
+// According to the RFC7591 (Section 2)
+// Ref: https://datatracker.ietf.org/doc/html/rfc7591#section-2
+type ClientRegistrationRequest struct {
+	RedirectUris            []string `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+}
+
+// ClientRegistrationResponse represents the response returned by '/register' endpoint
+// According to the RFC7591 (Section 3.2.1)
+// Ref: https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.1
+type ClientRegistrationResponse struct {
+	ClientID              string `json:"client_id"`                          // Required
+	ClientSecret          string `json:"client_secret,omitempty"`            // Optional
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at,omitempty"`      // Optional
+	ClientSecretExpiresAt *int64 `json:"client_secret_expires_at,omitempty"` // Required when 'client_secret' is issued
+
+	ClientRegistrationRequest
+}
+
+// ClientRegistrationErrorResponse represents the error returned by '/register' endpoint
+type ClientRegistrationErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// HandleOauthClientRegistration process requests for endpoint: /register
+// Registrations meeting the policy get a pre-provisioned client, or one created in the IdP
+func (h *HandlersManager) HandleOauthClientRegistration(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestBytes, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxRegistrationBodyBytes))
+	if err != nil {
+		h.denyClientRegistration(response, RegistrationErrorInvalidClientMetadata, "Request body can not be read")
+		return
+	}
+
+	var registration ClientRegistrationRequest
+	if err := json.Unmarshal(requestBytes, &registration); err != nil {
+		h.denyClientRegistration(response, RegistrationErrorInvalidClientMetadata, "Request body is not valid client metadata")
+		return
+	}
+
+	if errorCode, errorDescription := h.checkClientRegistrationPolicy(&registration); errorCode != "" {
+		h.dependencies.AppCtx.Logger.Debug("client registration denied", "client_name", registration.ClientName,
+			"error", errorDescription)
+		h.denyClientRegistration(response, errorCode, errorDescription)
+		return
+	}
+
+	// Clients of the built-in authorization server are its own, whatever the strategy is
+	if h.oauthProxy != nil {
+		h.registerOAuthProxyClient(response, registration)
+		return
+	}
+
+	switch h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.Strategy {
+	case "upstream":
+		h.registerUpstreamClient(response, requestBytes)
+	default:
+		h.registerStaticClient(response, registration, h.hasInitialAccessToken(request))
+	}
+}
+
+// checkClientRegistrationPolicy checks the metadata of a registration against the policy, filling default values.
+// An error code and its description are returned when it is not allowed
+func (h *HandlersManager) checkClientRegistrationPolicy(registration *ClientRegistrationRequest) (string, string) {
+	registrationConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration
+
+	if len(registration.GrantTypes) == 0 {
+		registration.GrantTypes = []string{"authorization_code"}
+	}
+	if len(registration.ResponseTypes) == 0 {
+		registration.ResponseTypes = []string{"code"}
+	}
+
+	for _, grantType := range registration.GrantTypes {
+		if !slices.Contains(registrationConfig.AllowedGrantTypes, grantType) {
+			return RegistrationErrorInvalidClientMetadata, "Grant type '" + grantType + "' is not allowed"
+		}
+	}
+
+	if slices.Contains(registration.GrantTypes, "authorization_code") && len(registration.RedirectUris) == 0 {
+		return RegistrationErrorInvalidRedirectUri, "Redirect URIs are required"
+	}
+
+	for _, redirectUri := range registration.RedirectUris {
+		if !isRedirectUriAllowed(redirectUri, registrationConfig.AllowedRedirectUris) {
+			return RegistrationErrorInvalidRedirectUri, "Redirect URI '" + redirectUri + "' is not allowed"
+		}
+	}
+
+	return "", ""
+}
+
+// registerStaticClient answers a registration with the first pre-provisioned client matching it.
+// Public clients (auth method 'none') only match clients without secret, and the other way around.
+// Clients with secret are only handed out to registrations authorized by the initial access token
+func (h *HandlersManager) registerStaticClient(response http.ResponseWriter, registration ClientRegistrationRequest,
+	authorized bool) {
+	for _, client := range h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.Clients {
+		if client.ClientName != "" {
+			if matched, _ := path.Match(client.ClientName, registration.ClientName); !matched {
+				continue
+			}
+		}
+
+		isPublic := client.ClientSecret == ""
+		if !isPublic && !authorized {
+			continue
+		}
+
+		switch registration.TokenEndpointAuthMethod {
+		case "":
+		case "none":
+			if !isPublic {
+				continue
+			}
+		default:
+			if isPublic {
+				continue
+			}
+		}
+
+		registrationResponse := ClientRegistrationResponse{
+			ClientID:                  client.ClientID,
+			ClientIDIssuedAt:          time.Now().Unix(),
+			ClientRegistrationRequest: registration,
+		}
+
+		if isPublic {
+			registrationResponse.TokenEndpointAuthMethod = "none"
+		} else {
+			neverExpires := int64(0)
+			registrationResponse.ClientSecret = client.ClientSecret
+			registrationResponse.ClientSecretExpiresAt = &neverExpires
+			if registrationResponse.TokenEndpointAuthMethod == "" {
+				registrationResponse.TokenEndpointAuthMethod = "client_secret_basic"
+			}
+		}
+
+		h.writeClientRegistration(response, http.StatusCreated, registrationResponse)
+		return
+	}
+
+	h.denyClientRegistration(response, RegistrationErrorInvalidClientMetadata, "No client is available for this registration")
+}
+
+// hasInitialAccessToken checks whether a registration carries the configured initial access token
+// Ref: https://datatracker.ietf.org/doc/html/rfc7591#section-3
+func (h *HandlersManager) hasInitialAccessToken(request *http.Request) bool {
+	initialAccessToken := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.InitialAccessToken
+	if initialAccessToken == "" {
+		return false
+	}
+
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(initialAccessToken)) == 1
+}
+
+// registerOAuthProxyClient registers a public client in the built-in authorization server.
+// It is bound to the redirect URIs of the registration, which are the only ones allowed on '/authorize' later
+func (h *HandlersManager) registerOAuthProxyClient(response http.ResponseWriter, registration ClientRegistrationRequest) {
+	for _, grantType := range registration.GrantTypes {
+		if grantType != "authorization_code" {
+			h.denyClientRegistration(response, RegistrationErrorInvalidClientMetadata,
+				"Grant type '"+grantType+"' is not supported")
+			return
+		}
+	}
+
+	if h.oauthProxy.clients.Len() >= maxOAuthProxyClients {
+		h.dependencies.AppCtx.Logger.Warn("client registration denied, too many clients registered",
+			"max_clients", maxOAuthProxyClients)
+		h.denyClientRegistration(response, RegistrationErrorInvalidClientMetadata, "No client is available for this registration")
+		return
+	}
+
+	registrationResponse := ClientRegistrationResponse{
+		ClientID:                  rand.Text(),
+		ClientIDIssuedAt:          time.Now().Unix(),
+		ClientRegistrationRequest: registration,
+	}
+	registrationResponse.TokenEndpointAuthMethod = "none"
+
+	h.oauthProxy.clients.Put(registrationResponse.ClientID,
+		oauthProxyClient{ClientName: registration.ClientName, RedirectUris: slices.Clone(registration.RedirectUris)},
+		oauthProxyClientTTL)
+
+	h.writeClientRegistration(response, http.StatusCreated, registrationResponse)
+}
+
+// registerUpstreamClient forwards a registration to the registration endpoint of the IdP, relaying its response
+func (h *HandlersManager) registerUpstreamClient(response http.ResponseWriter, requestBytes []byte) {
+	upstreamConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.Upstream
+
+	upstreamRequest, err := http.NewRequest(http.MethodPost, upstreamConfig.Endpoint, bytes.NewReader(requestBytes))
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error creating upstream registration request", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	upstreamRequest.Header.Set("Content-Type", "application/json")
+	upstreamRequest.Header.Set("Accept", "application/json")
+	if upstreamConfig.InitialAccessToken != "" {
+		upstreamRequest.Header.Set("Authorization", "Bearer "+upstreamConfig.InitialAccessToken)
+	}
+
+	upstreamResponse, err := h.httpClient.Do(upstreamRequest)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error registering client upstream", "error", err.Error())
+		http.Error(response, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer upstreamResponse.Body.Close()
+
+	upstreamResponseBytes, err := io.ReadAll(upstreamResponse.Body)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error reading bytes from remote response", "error", err.Error())
+		http.Error(response, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	if upstreamResponse.StatusCode >= http.StatusInternalServerError {
+		h.dependencies.AppCtx.Logger.Error("unexpected status code from upstream registration", "status", upstreamResponse.Status)
+		http.Error(response, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(upstreamResponse.StatusCode)
+
+	_, err = response.Write(upstreamResponseBytes)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
+
+// denyClientRegistration answers a registration with an error, as stated in the RFC
+func (h *HandlersManager) denyClientRegistration(response http.ResponseWriter, errorCode, errorDescription string) {
+	h.writeClientRegistration(response, http.StatusBadRequest, ClientRegistrationErrorResponse{
+		Error:            errorCode,
+		ErrorDescription: errorDescription,
+	})
+}
+
+// writeClientRegistration sends a registration response. Credentials must never be cached
+func (h *HandlersManager) writeClientRegistration(response http.ResponseWriter, statusCode int, responseObject any) {
+	responseObjectBytes, err := json.Marshal(responseObject)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error converting response into json", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(statusCode)
+
+	_, err = response.Write(responseObjectBytes)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
+
+// redirectUriPattern represents an allowed redirect URI pattern, split into the parts that are matched separately
+type redirectUriPattern struct {
+	scheme string
+	host   string
+	port   string
+	path   string
+}
+
+// isRedirectUriAllowed checks whether a redirect URI matches any of the allowed patterns.
+// Scheme, host, port and path are matched separately, so wildcards never reach other parts of the URI.
+// URIs with fragments or user info are never allowed, as stated in RFC 6749 and RFC 9700
+func isRedirectUriAllowed(redirectUri string, allowedPatterns []string) bool {
+	redirectUrl, err := url.Parse(redirectUri)
+	if err != nil || redirectUrl.Scheme == "" || redirectUrl.Host == "" || redirectUrl.Opaque != "" ||
+		redirectUrl.User != nil || redirectUrl.Fragment != "" {
+		return false
+	}
+
+	redirectPath := redirectUrl.EscapedPath()
+	if redirectPath == "" {
+		redirectPath = "/"
+	}
+
+	for _, allowedPattern := range allowedPatterns {
+		pattern, ok := parseRedirectUriPattern(allowedPattern)
+		if !ok {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern.scheme, strings.ToLower(redirectUrl.Scheme)); !matched {
+			continue
+		}
+		if matched, _ := path.Match(pattern.host, strings.ToLower(redirectUrl.Hostname())); !matched {
+			continue
+		}
+		if matched, _ := path.Match(pattern.port, redirectUrl.Port()); !matched {
+			continue
+		}
+		if matched, _ := path.Match(pattern.path, redirectPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRedirectUriPattern splits a pattern like 'http://localhost:*/*' into its parts.
+// Patterns are not parsed as URLs, as wildcards are not valid in every part of them
+func parseRedirectUriPattern(pattern string) (redirectUriPattern, bool) {
+	scheme, rest, found := strings.Cut(pattern, "://")
+	if !found || scheme == "" {
+		return redirectUriPattern{}, false
+	}
+
+	authority, patternPath := rest, "/"
+	if index := strings.Index(rest, "/"); index >= 0 {
+		authority, patternPath = rest[:index], rest[index:]
+	}
+	if authority == "" || strings.Contains(authority, "@") {
+		return redirectUriPattern{}, false
+	}
+
+	// Last colon separates the port, unless it belongs to an IPv6 address
+	host, port := authority, ""
+	if index := strings.LastIndex(authority, ":"); index >= 0 && !strings.HasSuffix(authority, "]") {
+		host, port = authority[:index], authority[index+1:]
+	}
+
+	return redirectUriPattern{
+		scheme: strings.ToLower(scheme),
+		host:   strings.ToLower(strings.Trim(host, "[]")),
+		port:   port,
+		path:   patternPath,
+	}, true
+}
@@ -0,0 +1,827 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-proxy/api"
+	"mcp-proxy/internal/middlewares"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Paths where the built-in authorization server is served
+	OAuthProxyAuthorizePath = "/authorize"
+	OAuthProxyTokenPath     = "/token"
+	OAuthProxyCallbackPath  = "/callback"
+	OAuthProxyJWKSPath      = "/.well-known/jwks.json"
+
+	// Error codes of the authorization and token endpoints
+	// Ref: https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1
+	OAuthErrorInvalidRequest          = "invalid_request"
+	OAuthErrorAccessDenied            = "access_denied"
+	OAuthErrorInvalidClient           = "invalid_client"
+	OAuthErrorInvalidGrant            = "invalid_grant"
+	OAuthErrorInvalidTarget           = "invalid_target"
+	OAuthErrorUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrorUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrorServerError             = "server_error"
+
+	// Time clients have to complete the login in the IdP, and to redeem the authorization code
+	oauthProxyTransactionTTL = 10 * time.Minute
+	oauthProxyCodeTTL        = time.Minute
+
+	// Time clients registered in the built-in authorization server are kept, and how many of them at most
+	oauthProxyClientTTL  = 7 * 24 * time.Hour
+	maxOAuthProxyClients = 10000
+
+	// Clock skew tolerated between the proxy and the IdP when checking ID tokens
+	oauthProxyIDTokenLeeway = 30 * time.Second
+)
+
+// oauthProxyClient represents a client registered in the built-in authorization server
+type oauthProxyClient struct {
+	ClientName   string
+	RedirectUris []string
+}
+
+// oauthProxyTransaction represents an authorization request of a client, waiting for the login in the IdP
+type oauthProxyTransaction struct {
+	ClientID      string
+	RedirectUri   string
+	State         string
+	CodeChallenge string
+	Scope         string
+
+	// Nonce binds the ID token of the IdP to this request
+	Nonce string
+}
+
+// oauthProxyGrant represents an authorization code issued to a client, waiting to be redeemed
+type oauthProxyGrant struct {
+	oauthProxyTransaction
+	Claims map[string]any
+}
+
+// oauthProxy keeps the state of the built-in authorization server.
+// Pending requests and codes are kept in memory, so every request of a flow must reach the same replica
+type oauthProxy struct {
+	signer       *oauthProxySigner
+	clients      *expiringStore[oauthProxyClient]
+	transactions *expiringStore[oauthProxyTransaction]
+	consents     *expiringStore[oauthProxyGrant]
+	codes        *expiringStore[oauthProxyGrant]
+
+	// Clients approved by the users, by user, client and redirect URI
+	approvals *expiringStore[bool]
+
+	// Keys of the IdP, to verify its ID tokens. Recreated when its JWKS URI changes
+	upstreamKeysMutex sync.Mutex
+	upstreamKeys      *middlewares.KeySet
+	upstreamJWKSUri   string
+}
+
+// OAuthProxyTokenResponse represents the response returned by '/token' endpoint
+// Ref: https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+type OAuthProxyTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// OAuthErrorResponse represents the error returned by '/token' endpoint
+// Ref: https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// newOAuthProxy creates the state of the built-in authorization server, loading its signing key
+func newOAuthProxy(config api.OAuthProxyConfig) (*oauthProxy, error) {
+	signer, err := newOAuthProxySigner(config.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauthProxy{
+		signer:       signer,
+		clients:      newExpiringStore[oauthProxyClient](),
+		transactions: newExpiringStore[oauthProxyTransaction](),
+		consents:     newExpiringStore[oauthProxyGrant](),
+		codes:        newExpiringStore[oauthProxyGrant](),
+		approvals:    newExpiringStore[bool](),
+	}, nil
+}
+
+// OAuthProxyIssuer returns the issuer of the tokens of the built-in authorization server, with its keys,
+// so they can be trusted by the local JWT validation. It is not found when the built-in server is disabled
+func (h *HandlersManager) OAuthProxyIssuer() (api.JWTValidationIssuerConfig, bool) {
+	if h.oauthProxy == nil {
+		return api.JWTValidationIssuerConfig{}, false
+	}
+
+	return api.JWTValidationIssuerConfig{
+		Issuer: h.getOAuthProxyIssuer(),
+		JWTValidationJWKSConfig: api.JWTValidationJWKSConfig{
+			JWKS: string(h.oauthProxy.signer.jwks),
+		},
+		Audiences:  []string{h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource},
+		Algorithms: []string{h.oauthProxy.signer.signingMethod.Alg()},
+	}, true
+}
+
+// getOAuthProxyIssuer returns the issuer of the built-in authorization server, which is the base of its endpoints
+func (h *HandlersManager) getOAuthProxyIssuer() string {
+	if issuer := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy.Issuer; issuer != "" {
+		return strings.TrimRight(issuer, "/")
+	}
+
+	resourceUrl, err := url.Parse(h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource)
+	if err != nil || resourceUrl.Scheme == "" || resourceUrl.Host == "" {
+		return ""
+	}
+	return resourceUrl.Scheme + "://" + resourceUrl.Host
+}
+
+// getOAuthProxyMetadata returns the metadata of the built-in authorization server
+// Ref: https://datatracker.ietf.org/doc/html/rfc8414#section-2
+func (h *HandlersManager) getOAuthProxyMetadata() map[string]any {
+	issuer := h.getOAuthProxyIssuer()
+
+	metadata := map[string]any{
+		"issuer":                                         issuer,
+		"authorization_endpoint":                         issuer + OAuthProxyAuthorizePath,
+		"token_endpoint":                                 issuer + OAuthProxyTokenPath,
+		"jwks_uri":                                       issuer + OAuthProxyJWKSPath,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"none", "client_secret_basic", "client_secret_post"},
+		"authorization_response_iss_parameter_supported": true,
+	}
+
+	if scopesSupported := h.dependencies.AppCtx.Config.OAuthProtectedResource.ScopesSupported; len(scopesSupported) > 0 {
+		metadata["scopes_supported"] = scopesSupported
+	}
+
+	return metadata
+}
+
+// HandleOauthProxyAuthorize process requests for endpoint: /authorize
+// Requests of clients are validated and remembered, then the user is sent to log in the IdP
+func (h *HandlersManager) HandleOauthProxyAuthorize(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		response.Header().Set("Allow", http.MethodGet)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := request.URL.Query()
+	transaction := oauthProxyTransaction{
+		ClientID:      query.Get("client_id"),
+		RedirectUri:   query.Get("redirect_uri"),
+		State:         query.Get("state"),
+		CodeChallenge: query.Get("code_challenge"),
+		Scope:         h.getOAuthProxyScope(query.Get("scope")),
+		Nonce:         rand.Text(),
+	}
+
+	// Errors can not be sent to redirect URIs that are not trusted
+	if !h.isOAuthProxyClientAllowed(transaction.ClientID, transaction.RedirectUri) {
+		http.Error(response, "Unknown client or redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("response_type") != "code" {
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorUnsupportedResponseType, "Only 'code' response type is supported")
+		return
+	}
+
+	if transaction.CodeChallenge == "" || query.Get("code_challenge_method") != "S256" {
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorInvalidRequest, "PKCE with 'S256' method is required")
+		return
+	}
+
+	// Tokens are only issued for the protected resource
+	// Ref: https://datatracker.ietf.org/doc/html/rfc8707#section-2
+	if resource := query.Get("resource"); resource != "" && resource != h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource {
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorInvalidTarget, "Unknown resource")
+		return
+	}
+
+	metadata, err := h.getAuthorizationServerMetadata()
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error getting authorization server metadata", "error", err.Error())
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorServerError, "IdP is not available")
+		return
+	}
+
+	authorizationEndpoint, _ := metadata["authorization_endpoint"].(string)
+	authorizationUrl, err := url.Parse(authorizationEndpoint)
+	if err != nil || authorizationEndpoint == "" {
+		h.dependencies.AppCtx.Logger.Error("authorization server metadata has no valid 'authorization_endpoint'")
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorServerError, "IdP is not available")
+		return
+	}
+
+	transactionID := rand.Text()
+	h.oauthProxy.transactions.Put(transactionID, transaction, oauthProxyTransactionTTL)
+
+	proxyConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy
+	authorizationQuery := authorizationUrl.Query()
+	authorizationQuery.Set("response_type", "code")
+	authorizationQuery.Set("client_id", proxyConfig.ClientID)
+	authorizationQuery.Set("redirect_uri", h.getOAuthProxyIssuer()+OAuthProxyCallbackPath)
+	authorizationQuery.Set("scope", h.getOAuthProxyUpstreamScope(transaction.Scope))
+	authorizationQuery.Set("state", transactionID)
+	authorizationQuery.Set("nonce", transaction.Nonce)
+	authorizationUrl.RawQuery = authorizationQuery.Encode()
+
+	http.Redirect(response, request, authorizationUrl.String(), http.StatusFound)
+}
+
+// HandleOauthProxyCallback process requests for endpoint: /callback
+// The code of the IdP is exchanged for the claims of the user, and a code of the proxy is sent to the client
+func (h *HandlersManager) HandleOauthProxyCallback(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		response.Header().Set("Allow", http.MethodGet)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := request.URL.Query()
+	transaction, found := h.oauthProxy.transactions.Take(query.Get("state"))
+	if !found {
+		http.Error(response, "Unknown or expired authorization request", http.StatusBadRequest)
+		return
+	}
+
+	// Errors of the IdP, such as the user denying the access, are relayed to the client
+	if upstreamError := query.Get("error"); upstreamError != "" {
+		h.redirectOAuthProxyError(response, request, transaction, upstreamError, query.Get("error_description"))
+		return
+	}
+
+	claims, grantedScope, err := h.exchangeUpstreamCode(query.Get("code"), transaction)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error exchanging authorization code with IdP", "error", err.Error())
+		h.redirectOAuthProxyError(response, request, transaction, OAuthErrorServerError, "Authorization code can not be exchanged")
+		return
+	}
+
+	// Tokens only carry the requested scopes the user was actually granted, and the protected resource supports
+	transaction.Scope = intersectScopes(transaction.Scope, h.getOAuthProxyScope(grantedScope))
+
+	grant := oauthProxyGrant{
+		oauthProxyTransaction: transaction,
+		Claims:                claims,
+	}
+
+	// The IdP only knows the proxy, so clients registered by anyone must be approved by the user
+	if h.isOAuthProxyConsentRequired(grant) {
+		h.askOAuthProxyConsent(response, grant)
+		return
+	}
+
+	h.issueOAuthProxyCode(response, request, grant)
+}
+
+// HandleOauthProxyToken process requests for endpoint: /token
+// Authorization codes are redeemed for short-lived tokens signed by the proxy
+func (h *HandlersManager) HandleOauthProxyToken(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := request.ParseForm(); err != nil {
+		h.denyOAuthProxyToken(response, http.StatusBadRequest, OAuthErrorInvalidRequest, "Request body can not be parsed")
+		return
+	}
+
+	if request.PostForm.Get("grant_type") != "authorization_code" {
+		h.denyOAuthProxyToken(response, http.StatusBadRequest, OAuthErrorUnsupportedGrantType, "Only 'authorization_code' grant type is supported")
+		return
+	}
+
+	clientID, clientSecret, hasBasicAuth := request.BasicAuth()
+	if !hasBasicAuth {
+		clientID = request.PostForm.Get("client_id")
+		clientSecret = request.PostForm.Get("client_secret")
+	}
+
+	if !h.isOAuthProxyClientAuthenticated(clientID, clientSecret) {
+		h.denyOAuthProxyToken(response, http.StatusUnauthorized, OAuthErrorInvalidClient, "Client authentication failed")
+		return
+	}
+
+	// Codes are redeemed once, even when the request is not valid
+	grant, found := h.oauthProxy.codes.Take(request.PostForm.Get("code"))
+	if !found || grant.ClientID != clientID || grant.RedirectUri != request.PostForm.Get("redirect_uri") {
+		h.denyOAuthProxyToken(response, http.StatusBadRequest, OAuthErrorInvalidGrant, "Invalid authorization code")
+		return
+	}
+
+	verifierHash := sha256.Sum256([]byte(request.PostForm.Get("code_verifier")))
+	verifierChallenge := base64.RawURLEncoding.EncodeToString(verifierHash[:])
+	if subtle.ConstantTimeCompare([]byte(verifierChallenge), []byte(grant.CodeChallenge)) != 1 {
+		h.denyOAuthProxyToken(response, http.StatusBadRequest, OAuthErrorInvalidGrant, "Invalid code verifier")
+		return
+	}
+
+	proxyConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for _, claim := range proxyConfig.ForwardedClaims {
+		if value, found := grant.Claims[claim]; found {
+			claims[claim] = value
+		}
+	}
+	claims["iss"] = h.getOAuthProxyIssuer()
+	claims["sub"] = grant.Claims["sub"]
+	claims["aud"] = h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource
+	claims["client_id"] = grant.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(proxyConfig.TokenTTL).Unix()
+	claims["jti"] = rand.Text()
+	if grant.Scope != "" {
+		claims["scope"] = grant.Scope
+	}
+
+	accessToken, err := h.oauthProxy.signer.Sign(claims)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error signing access token", "error", err.Error())
+		h.denyOAuthProxyToken(response, http.StatusInternalServerError, OAuthErrorServerError, "Token can not be issued")
+		return
+	}
+
+	h.writeOAuthProxyJSON(response, http.StatusOK, OAuthProxyTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(proxyConfig.TokenTTL.Seconds()),
+		Scope:       grant.Scope,
+	})
+}
+
+// HandleOauthProxyJWKS process requests for endpoint: /.well-known/jwks.json
+func (h *HandlersManager) HandleOauthProxyJWKS(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		response.Header().Set("Allow", http.MethodGet)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "max-age=300")
+
+	_, err := response.Write(h.oauthProxy.signer.jwks)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
+
+// exchangeUpstreamCode redeems an authorization code of the IdP, and returns the claims of the user
+// along with the scopes granted to them.
+// Claims are read from the ID token, or from the userinfo endpoint when it is not issued
+func (h *HandlersManager) exchangeUpstreamCode(code string, transaction oauthProxyTransaction) (map[string]any, string, error) {
+	if code == "" {
+		return nil, "", fmt.Errorf("authorization code not found")
+	}
+
+	metadata, err := h.getAuthorizationServerMetadata()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tokenEndpoint, _ := metadata["token_endpoint"].(string)
+	if tokenEndpoint == "" {
+		return nil, "", fmt.Errorf("authorization server metadata has no 'token_endpoint'")
+	}
+
+	proxyConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {h.getOAuthProxyIssuer() + OAuthProxyCallbackPath},
+		"client_id":    {proxyConfig.ClientID},
+	}
+
+	tokenRequest, err := http.NewRequest(http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed creating token request: %s", err.Error())
+	}
+	tokenRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRequest.Header.Set("Accept", "application/json")
+	tokenRequest.SetBasicAuth(url.QueryEscape(proxyConfig.ClientID), url.QueryEscape(proxyConfig.ClientSecret))
+
+	tokenResponse, err := h.httpClient.Do(tokenRequest)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed requesting token: %s", err.Error())
+	}
+	defer tokenResponse.Body.Close()
+
+	if tokenResponse.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected token status code: %d", tokenResponse.StatusCode)
+	}
+
+	tokens := struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Scope       string `json:"scope"`
+	}{}
+	if err := json.NewDecoder(tokenResponse.Body).Decode(&tokens); err != nil {
+		return nil, "", fmt.Errorf("failed decoding token response: %s", err.Error())
+	}
+
+	var claims map[string]any
+	if tokens.IDToken != "" {
+		claims, err = h.verifyUpstreamIDToken(metadata, tokens.IDToken, transaction.Nonce)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		claims, err = h.getUpstreamUserinfo(metadata, tokens.AccessToken)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, "", fmt.Errorf("user claims have no 'sub'")
+	}
+
+	return claims, h.getOAuthProxyGrantedScope(transaction.Scope, tokens.Scope, claims), nil
+}
+
+// verifyUpstreamIDToken verifies an ID token of the IdP, and returns its claims.
+// It must be signed with the published keys of the IdP, issued to the proxy and bound to the request by its nonce
+// Ref: https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func (h *HandlersManager) verifyUpstreamIDToken(metadata map[string]any, idToken, nonce string) (map[string]any, error) {
+	issuer, _ := metadata["issuer"].(string)
+	if issuer == "" {
+		issuer = h.dependencies.AppCtx.Config.OAuthAuthorizationServer.IssuerUri
+	}
+
+	keys, err := h.getUpstreamKeys(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy.ClientID
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, keys.Keyfunc,
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(oauthProxyIDTokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %s", err.Error())
+	}
+
+	// Tokens with several audiences must be issued to the proxy
+	if audiences, _ := claims.GetAudience(); len(audiences) > 1 {
+		if azp, _ := claims["azp"].(string); azp != clientID {
+			return nil, fmt.Errorf("invalid ID token: authorized party is not the proxy")
+		}
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("invalid ID token: nonce does not match the authorization request")
+	}
+
+	return claims, nil
+}
+
+// getUpstreamKeys returns the keys published by the IdP, from the JWKS URI of its metadata
+func (h *HandlersManager) getUpstreamKeys(metadata map[string]any) (*middlewares.KeySet, error) {
+	jwksUri, _ := metadata["jwks_uri"].(string)
+	if jwksUri == "" {
+		return nil, fmt.Errorf("authorization server metadata has no 'jwks_uri', ID tokens can not be verified")
+	}
+
+	h.oauthProxy.upstreamKeysMutex.Lock()
+	defer h.oauthProxy.upstreamKeysMutex.Unlock()
+
+	if h.oauthProxy.upstreamKeys == nil || h.oauthProxy.upstreamJWKSUri != jwksUri {
+		h.oauthProxy.upstreamKeys = middlewares.NewKeySet(jwksUri, api.DefaultJWKSMinRefreshInterval, h.dependencies.AppCtx.Logger)
+		h.oauthProxy.upstreamJWKSUri = jwksUri
+	}
+
+	return h.oauthProxy.upstreamKeys, nil
+}
+
+// getUpstreamUserinfo obtains the claims of the user from the userinfo endpoint of the IdP
+func (h *HandlersManager) getUpstreamUserinfo(metadata map[string]any, accessToken string) (map[string]any, error) {
+	userinfoEndpoint, _ := metadata["userinfo_endpoint"].(string)
+	if userinfoEndpoint == "" || accessToken == "" {
+		return nil, fmt.Errorf("IdP issued no ID token, and user claims can not be requested")
+	}
+
+	userinfoRequest, err := http.NewRequest(http.MethodGet, userinfoEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating userinfo request: %s", err.Error())
+	}
+	userinfoRequest.Header.Set("Authorization", "Bearer "+accessToken)
+	userinfoRequest.Header.Set("Accept", "application/json")
+
+	userinfoResponse, err := h.httpClient.Do(userinfoRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting userinfo: %s", err.Error())
+	}
+	defer userinfoResponse.Body.Close()
+
+	if userinfoResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected userinfo status code: %d", userinfoResponse.StatusCode)
+	}
+
+	var claims map[string]any
+	if err := json.NewDecoder(userinfoResponse.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed decoding userinfo: %s", err.Error())
+	}
+
+	return claims, nil
+}
+
+// getOAuthProxyScope returns the requested scopes the protected resource supports.
+// Every requested scope is kept when the supported ones are not configured
+func (h *HandlersManager) getOAuthProxyScope(requestedScope string) string {
+	scopesSupported := h.dependencies.AppCtx.Config.OAuthProtectedResource.ScopesSupported
+	if len(scopesSupported) == 0 {
+		return requestedScope
+	}
+
+	scopes := []string{}
+	for _, scope := range strings.Fields(requestedScope) {
+		if slices.Contains(scopesSupported, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// getOAuthProxyUpstreamScope returns the scopes requested to the IdP: the ones of the proxy to log the user in,
+// and the ones requested by the client, so the IdP decides whether to grant them.
+// Requested scopes are not sent when they are granted by the scope mappings instead
+func (h *HandlersManager) getOAuthProxyUpstreamScope(requestedScope string) string {
+	proxyConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy
+
+	scopes := slices.Clone(proxyConfig.Scopes)
+	if len(proxyConfig.ScopeMappings) == 0 {
+		for _, scope := range strings.Fields(requestedScope) {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// getOAuthProxyGrantedScope returns the scopes granted to the user. They come from the scope mappings when configured,
+// or from the token response of the IdP otherwise, which only omits them when it granted the requested ones
+// Ref: https://datatracker.ietf.org/doc/html/rfc6749#section-5.1
+func (h *HandlersManager) getOAuthProxyGrantedScope(requestedScope, upstreamScope string, claims map[string]any) string {
+	scopeMappings := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy.ScopeMappings
+	if len(scopeMappings) == 0 {
+		if upstreamScope == "" {
+			return h.getOAuthProxyUpstreamScope(requestedScope)
+		}
+		return upstreamScope
+	}
+
+	scopes := []string{}
+	for _, mapping := range scopeMappings {
+		if slices.Contains(scopes, mapping.Scope) {
+			continue
+		}
+
+		claimValues := []any{claims[mapping.Claim]}
+		if values, ok := claims[mapping.Claim].([]any); ok {
+			claimValues = values
+		}
+
+		if slices.ContainsFunc(claimValues, func(value any) bool {
+			claimValue, _ := value.(string)
+			return claimValue != "" && slices.Contains(mapping.Values, claimValue)
+		}) {
+			scopes = append(scopes, mapping.Scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// intersectScopes returns the requested scopes that were granted.
+// Every granted scope is returned when none is requested
+func intersectScopes(requestedScope, grantedScope string) string {
+	grantedScopes := strings.Fields(grantedScope)
+	if requestedScope == "" {
+		return strings.Join(grantedScopes, " ")
+	}
+
+	scopes := []string{}
+	for _, scope := range strings.Fields(requestedScope) {
+		if slices.Contains(grantedScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// isOAuthProxyClientAllowed checks whether a client can request authorization with a redirect URI.
+// Registered clients are bound to the exact redirect URIs they registered, and pre-provisioned ones
+// to their own patterns, or the ones of the registration policy. Unknown clients are never allowed
+func (h *HandlersManager) isOAuthProxyClientAllowed(clientID, redirectUri string) bool {
+	if clientID == "" {
+		return false
+	}
+
+	if client, found := h.oauthProxy.clients.Get(clientID); found {
+		return slices.Contains(client.RedirectUris, redirectUri)
+	}
+
+	registrationConfig := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration
+	for _, client := range registrationConfig.Clients {
+		if client.ClientID != clientID {
+			continue
+		}
+
+		allowedRedirectUris := client.RedirectUris
+		if len(allowedRedirectUris) == 0 {
+			allowedRedirectUris = registrationConfig.AllowedRedirectUris
+		}
+		return isRedirectUriAllowed(redirectUri, allowedRedirectUris)
+	}
+	return false
+}
+
+// isOAuthProxyClientAuthenticated checks the secret of pre-provisioned confidential clients.
+// Public clients are authenticated by PKCE
+func (h *HandlersManager) isOAuthProxyClientAuthenticated(clientID, clientSecret string) bool {
+	if clientID == "" {
+		return false
+	}
+
+	for _, client := range h.dependencies.AppCtx.Config.OAuthAuthorizationServer.ClientRegistration.Clients {
+		if client.ClientID == clientID && client.ClientSecret != "" {
+			return subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(clientSecret)) == 1
+		}
+	}
+	return true
+}
+
+// redirectOAuthProxyError sends an error to the redirect URI of the client
+func (h *HandlersManager) redirectOAuthProxyError(response http.ResponseWriter, request *http.Request,
+	transaction oauthProxyTransaction, errorCode, errorDescription string) {
+
+	params := url.Values{"error": {errorCode}}
+	if errorDescription != "" {
+		params.Set("error_description", errorDescription)
+	}
+	h.redirectOAuthProxy(response, request, transaction, params)
+}
+
+// redirectOAuthProxy sends the user back to the redirect URI of the client, along with the state and the issuer
+// Ref: https://datatracker.ietf.org/doc/html/rfc9207
+func (h *HandlersManager) redirectOAuthProxy(response http.ResponseWriter, request *http.Request,
+	transaction oauthProxyTransaction, params url.Values) {
+
+	redirectUrl, err := url.Parse(transaction.RedirectUri)
+	if err != nil {
+		http.Error(response, "Invalid redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	query := redirectUrl.Query()
+	for param, values := range params {
+		query[param] = values
+	}
+	if transaction.State != "" {
+		query.Set("state", transaction.State)
+	}
+	query.Set("iss", h.getOAuthProxyIssuer())
+	redirectUrl.RawQuery = query.Encode()
+
+	http.Redirect(response, request, redirectUrl.String(), http.StatusFound)
+}
+
+// denyOAuthProxyToken answers a token request with an error, as stated in the RFC
+func (h *HandlersManager) denyOAuthProxyToken(response http.ResponseWriter, statusCode int, errorCode, errorDescription string) {
+	if statusCode == http.StatusUnauthorized {
+		response.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	}
+
+	h.writeOAuthProxyJSON(response, statusCode, OAuthErrorResponse{
+		Error:            errorCode,
+		ErrorDescription: errorDescription,
+	})
+}
+
+// writeOAuthProxyJSON sends a response of the token endpoint. Tokens must never be cached
+func (h *HandlersManager) writeOAuthProxyJSON(response http.ResponseWriter, statusCode int, responseObject any) {
+	responseObjectBytes, err := json.Marshal(responseObject)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error converting response into json", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(statusCode)
+
+	_, err = response.Write(responseObjectBytes)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
+
+// expiringStore keeps values for a limited time. Values are taken once
+type expiringStore[T any] struct {
+	mutex     sync.Mutex
+	entries   map[string]expiringEntry[T]
+	lastPrune time.Time
+}
+
+type expiringEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func newExpiringStore[T any]() *expiringStore[T] {
+	return &expiringStore[T]{
+		entries: map[string]expiringEntry[T]{},
+	}
+}
+
+// Put stores a value until the TTL passes, pruning expired ones from time to time
+func (s *expiringStore[T]) Put(key string, value T, ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for entryKey, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, entryKey)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	s.entries[key] = expiringEntry[T]{value: value, expiresAt: now.Add(ttl)}
+}
+
+// Get returns a value when it did not expire, keeping it
+func (s *expiringStore[T]) Get(key string) (T, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, found := s.entries[key]
+	if !found || time.Now().After(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// Len returns the number of stored values, including the expired ones not pruned yet
+func (s *expiringStore[T]) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.entries)
+}
+
+// Take returns a value when it did not expire, removing it
+func (s *expiringStore[T]) Take(key string) (T, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, found := s.entries[key]
+	delete(s.entries, key)
+
+	if !found || time.Now().After(entry.expiresAt) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// OAuthProxyConsentPath is the path where users approve clients of the built-in authorization server
+const OAuthProxyConsentPath = "/consent"
+
+// oauthProxyConsentTemplate asks the user to approve a client. It names the client and its redirect URI,
+// as anyone can register clients and the IdP only knows the proxy
+var oauthProxyConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize {{ .ClientName }}</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 4rem auto; padding: 0 1rem; }
+code { word-break: break-all; }
+button { margin-right: 0.5rem; padding: 0.5rem 1rem; }
+</style>
+</head>
+<body>
+<h1>Authorize {{ .ClientName }}</h1>
+<p>Signed in as <strong>{{ .User }}</strong>.</p>
+<p><strong>{{ .ClientName }}</strong> wants to access <code>{{ .Resource }}</code> on your behalf.</p>
+{{ if .Scope }}<p>Requested scopes: <code>{{ .Scope }}</code></p>{{ end }}
+<p>You will be redirected to <code>{{ .RedirectUri }}</code>. Only approve it when you started this login.</p>
+<form method="post" action="{{ .Action }}">
+<input type="hidden" name="consent_id" value="{{ .ConsentID }}">
+<button type="submit" name="action" value="approve">Approve</button>
+<button type="submit" name="action" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// HandleOauthProxyConsent process requests for endpoint: /consent
+// Pending grants are issued to the client when the user approves it, and the approval is remembered
+func (h *HandlersManager) HandleOauthProxyConsent(response http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		response.Header().Set("Allow", http.MethodPost)
+		http.Error(response, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := request.ParseForm(); err != nil {
+		http.Error(response, "Request body can not be parsed", http.StatusBadRequest)
+		return
+	}
+
+	grant, found := h.oauthProxy.consents.Take(request.PostForm.Get("consent_id"))
+	if !found {
+		http.Error(response, "Unknown or expired authorization request", http.StatusBadRequest)
+		return
+	}
+
+	if request.PostForm.Get("action") != "approve" {
+		h.redirectOAuthProxyError(response, request, grant.oauthProxyTransaction, OAuthErrorAccessDenied, "User denied the access")
+		return
+	}
+
+	h.oauthProxy.approvals.Put(getOAuthProxyApprovalKey(grant), true, oauthProxyClientTTL)
+	h.issueOAuthProxyCode(response, request, grant)
+}
+
+// isOAuthProxyConsentRequired checks whether the user must approve the client before issuing a grant.
+// Pre-provisioned clients are trusted by the operator, and registered ones only need it once per user
+func (h *HandlersManager) isOAuthProxyConsentRequired(grant oauthProxyGrant) bool {
+	if _, registered := h.oauthProxy.clients.Get(grant.ClientID); !registered {
+		return false
+	}
+
+	_, approved := h.oauthProxy.approvals.Get(getOAuthProxyApprovalKey(grant))
+	return !approved
+}
+
+// askOAuthProxyConsent keeps a grant until the user approves the client, and shows the consent page
+func (h *HandlersManager) askOAuthProxyConsent(response http.ResponseWriter, grant oauthProxyGrant) {
+	consentID := rand.Text()
+	h.oauthProxy.consents.Put(consentID, grant, oauthProxyTransactionTTL)
+
+	client, _ := h.oauthProxy.clients.Get(grant.ClientID)
+	clientName := client.ClientName
+	if clientName == "" {
+		clientName = grant.ClientID
+	}
+
+	var page bytes.Buffer
+	err := oauthProxyConsentTemplate.Execute(&page, map[string]any{
+		"ClientName":  clientName,
+		"User":        getOAuthProxyUserLabel(grant.Claims),
+		"Resource":    h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource,
+		"Scope":       grant.Scope,
+		"RedirectUri": grant.RedirectUri,
+		"Action":      h.getOAuthProxyIssuer() + OAuthProxyConsentPath,
+		"ConsentID":   consentID,
+	})
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error rendering consent page", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Consent page must never be framed, so users can not be tricked into approving clients
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.Header().Set("Cache-Control", "no-store")
+	response.Header().Set("X-Frame-Options", "DENY")
+	response.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+
+	_, err = response.Write(page.Bytes())
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
+
+// issueOAuthProxyCode stores a grant to be redeemed once, and sends its code to the client
+func (h *HandlersManager) issueOAuthProxyCode(response http.ResponseWriter, request *http.Request, grant oauthProxyGrant) {
+	code := rand.Text()
+	h.oauthProxy.codes.Put(code, grant, oauthProxyCodeTTL)
+
+	h.redirectOAuthProxy(response, request, grant.oauthProxyTransaction, url.Values{"code": {code}})
+}
+
+// getOAuthProxyApprovalKey returns the key of the approval of a client by a user, for a single redirect URI
+func getOAuthProxyApprovalKey(grant oauthProxyGrant) string {
+	sub, _ := grant.Claims["sub"].(string)
+	return sub + "\n" + grant.ClientID + "\n" + grant.RedirectUri
+}
+
+// getOAuthProxyUserLabel returns the most readable identifier of the user
+func getOAuthProxyUserLabel(claims map[string]any) string {
+	for _, claim := range []string{"email", "preferred_username", "name"} {
+		if value, _ := claims[claim].(string); value != "" {
+			return value
+		}
+	}
+
+	sub, _ := claims["sub"].(string)
+	return sub
+}
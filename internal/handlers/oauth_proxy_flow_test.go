@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	//
+	"mcp-proxy/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testCodeChallenge  = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	testUpstreamClient = "mcp-proxy"
+	testUpstreamKeyID  = "idp-key"
+	testClientState    = "client-state"
+)
+
+// testIdP is a fake IdP, issuing ID tokens bound to the nonce of each login
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mutex  sync.Mutex
+	nonces map[string]string
+
+	// Optional behavior of the token endpoint, set by tests
+	scope       string
+	claims      map[string]any
+	modifyToken func(claims jwt.MapClaims)
+	signingKey  *rsa.PrivateKey
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating IdP key: %v", err)
+	}
+
+	idp := &testIdP{key: key, nonces: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]any{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testUpstreamKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", idp.handleAuthorize)
+	mux.HandleFunc("/token", idp.handleToken)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// handleAuthorize logs the user in right away, remembering the nonce of the login by its code
+func (idp *testIdP) handleAuthorize(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	idp.mutex.Lock()
+	code := "idp-code-" + strconv.Itoa(len(idp.nonces))
+	idp.nonces[code] = query.Get("nonce")
+	idp.mutex.Unlock()
+
+	callbackUrl, _ := url.Parse(query.Get("redirect_uri"))
+	callbackUrl.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(rw, req, callbackUrl.String(), http.StatusFound)
+}
+
+// handleToken issues an ID token for the user of a code
+func (idp *testIdP) handleToken(rw http.ResponseWriter, req *http.Request) {
+	_ = req.ParseForm()
+
+	idp.mutex.Lock()
+	nonce, found := idp.nonces[req.PostForm.Get("code")]
+	idp.mutex.Unlock()
+	if !found {
+		http.Error(rw, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   testUpstreamClient,
+		"sub":   "user",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for claim, value := range idp.claims {
+		claims[claim] = value
+	}
+	if idp.modifyToken != nil {
+		idp.modifyToken(claims)
+	}
+
+	signingKey := idp.key
+	if idp.signingKey != nil {
+		signingKey = idp.signingKey
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testUpstreamKeyID
+	idToken, _ := token.SignedString(signingKey)
+
+	_ = json.NewEncoder(rw).Encode(map[string]any{
+		"access_token": "upstream-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+		"scope":        idp.scope,
+	})
+}
+
+// newTestOAuthProxyFlow returns a handlers manager whose built-in authorization server logs users in a fake IdP
+func newTestOAuthProxyFlow(t *testing.T) (*HandlersManager, *testIdP) {
+	t.Helper()
+
+	idp := newTestIdP(t)
+	hm := newTestOAuthProxyHandlers(t)
+
+	config := hm.dependencies.AppCtx.Config
+	config.OAuthAuthorizationServer.IssuerUri = idp.server.URL
+	config.OAuthAuthorizationServer.CacheTTL = time.Minute
+	config.OAuthAuthorizationServer.Proxy.ClientID = testUpstreamClient
+	config.OAuthAuthorizationServer.Proxy.Scopes = []string{"openid"}
+
+	return hm, idp
+}
+
+// loginTestUser performs the login of a user for a client, from the authorization request to the callback,
+// and returns the response of the callback
+func loginTestUser(t *testing.T, hm *HandlersManager, clientID, scope string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectUri},
+		"state":                 {testClientState},
+		"code_challenge":        {testCodeChallenge},
+		"code_challenge_method": {"S256"},
+		"scope":                 {scope},
+	}
+	authorizeRecorder := httptest.NewRecorder()
+	hm.HandleOauthProxyAuthorize(authorizeRecorder, httptest.NewRequest(http.MethodGet, OAuthProxyAuthorizePath+"?"+query.Encode(), nil))
+	if authorizeRecorder.Code != http.StatusFound {
+		t.Fatalf("authorize: got status %d, want %d", authorizeRecorder.Code, http.StatusFound)
+	}
+
+	upstreamUrl, _ := url.Parse(authorizeRecorder.Header().Get("Location"))
+	upstreamQuery := upstreamUrl.Query()
+	if upstreamQuery.Get("client_id") != testUpstreamClient || upstreamQuery.Get("nonce") == "" {
+		t.Fatalf("authorize: upstream request has client_id %q and nonce %q", upstreamQuery.Get("client_id"), upstreamQuery.Get("nonce"))
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	upstreamResponse, err := client.Get(upstreamUrl.String())
+	if err != nil {
+		t.Fatalf("failed logging in the IdP: %v", err)
+	}
+	upstreamResponse.Body.Close()
+
+	callbackUrl, _ := url.Parse(upstreamResponse.Header.Get("Location"))
+	callbackRecorder := httptest.NewRecorder()
+	hm.HandleOauthProxyCallback(callbackRecorder, httptest.NewRequest(http.MethodGet, OAuthProxyCallbackPath+"?"+callbackUrl.RawQuery, nil))
+	return callbackRecorder
+}
+
+// getTestClientRedirect returns the query sent to the redirect URI of the client
+func getTestClientRedirect(t *testing.T, recorder *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("got status %d, want a redirect to the client", recorder.Code)
+	}
+
+	location := recorder.Header().Get("Location")
+	if !strings.HasPrefix(location, testRedirectUri+"?") {
+		t.Fatalf("redirected to %q, want %q", location, testRedirectUri)
+	}
+
+	redirectUrl, _ := url.Parse(location)
+	query := redirectUrl.Query()
+	if query.Get("state") != testClientState {
+		t.Errorf("redirect has state %q, want %q", query.Get("state"), testClientState)
+	}
+	return query
+}
+
+// redeemTestCode exchanges a code of a public client for a token, and returns the token response
+func redeemTestCode(t *testing.T, hm *HandlersManager, code, clientID string) OAuthProxyTokenResponse {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, OAuthProxyTokenPath, strings.NewReader(getTestTokenForm(code, clientID, testCodeVerifier).Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	hm.HandleOauthProxyToken(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("token: got status %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+
+	tokenResponse := OAuthProxyTokenResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &tokenResponse); err != nil {
+		t.Fatalf("token response is not JSON: %v", err)
+	}
+	return tokenResponse
+}
+
+// getTestConsentID returns the identifier of the pending grant shown in a consent page
+func getTestConsentID(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want the consent page", recorder.Code)
+	}
+
+	consentID := regexp.MustCompile(`name="consent_id" value="([^"]+)"`).FindStringSubmatch(recorder.Body.String())
+	if consentID == nil {
+		t.Fatal("consent page has no consent_id")
+	}
+	return consentID[1]
+}
+
+// answerTestConsent sends the answer of the user in the consent page
+func answerTestConsent(hm *HandlersManager, consentID, action string) *httptest.ResponseRecorder {
+	form := url.Values{"consent_id": {consentID}, "action": {action}}
+	request := httptest.NewRequest(http.MethodPost, OAuthProxyConsentPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	hm.HandleOauthProxyConsent(recorder, request)
+	return recorder
+}
+
+func TestOAuthProxyFlow(t *testing.T) {
+	hm, idp := newTestOAuthProxyFlow(t)
+	idp.claims = map[string]any{"email": "user@example.com"}
+	hm.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy.ForwardedClaims = []string{"email"}
+
+	query := getTestClientRedirect(t, loginTestUser(t, hm, testPublicClientID, ""))
+	if query.Get("iss") != hm.getOAuthProxyIssuer() {
+		t.Errorf("redirect has issuer %q, want %q", query.Get("iss"), hm.getOAuthProxyIssuer())
+	}
+
+	tokenResponse := redeemTestCode(t, hm, query.Get("code"), testPublicClientID)
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenResponse.AccessToken, claims); err != nil {
+		t.Fatalf("access token can not be parsed: %v", err)
+	}
+	if claims["sub"] != "user" || claims["email"] != "user@example.com" || claims["client_id"] != testPublicClientID {
+		t.Errorf("access token has claims %v", claims)
+	}
+}
+
+func TestOAuthProxyFlowConsent(t *testing.T) {
+	hm, _ := newTestOAuthProxyFlow(t)
+	hm.oauthProxy.clients.Put("registered", oauthProxyClient{ClientName: "Registered client", RedirectUris: []string{testRedirectUri}}, time.Minute)
+
+	consentRecorder := loginTestUser(t, hm, "registered", "")
+	if !strings.Contains(consentRecorder.Body.String(), "Registered client") {
+		t.Errorf("consent page does not name the client")
+	}
+	consentID := getTestConsentID(t, consentRecorder)
+
+	if query := getTestClientRedirect(t, answerTestConsent(hm, consentID, "approve")); query.Get("code") == "" {
+		t.Errorf("approved consent redirected with %v, want a code", query)
+	}
+
+	if recorder := answerTestConsent(hm, consentID, "approve"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("reused consent: got status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	// Approvals are remembered for the same user, client and redirect URI
+	if query := getTestClientRedirect(t, loginTestUser(t, hm, "registered", "")); query.Get("code") == "" {
+		t.Errorf("second login redirected with %v, want a code", query)
+	}
+}
+
+func TestOAuthProxyFlowConsentDenied(t *testing.T) {
+	hm, _ := newTestOAuthProxyFlow(t)
+	hm.oauthProxy.clients.Put("registered", oauthProxyClient{RedirectUris: []string{testRedirectUri}}, time.Minute)
+
+	consentID := getTestConsentID(t, loginTestUser(t, hm, "registered", ""))
+
+	if query := getTestClientRedirect(t, answerTestConsent(hm, consentID, "deny")); query.Get("error") != OAuthErrorAccessDenied || query.Get("code") != "" {
+		t.Errorf("denied consent redirected with %v, want error %q", query, OAuthErrorAccessDenied)
+	}
+}
+
+func TestOAuthProxyFlowScope(t *testing.T) {
+	writersMapping := []api.OAuthProxyScopeMappingConfig{{Scope: "mcp:write", Claim: "groups", Values: []string{"writers"}}}
+
+	tests := []struct {
+		name            string
+		scopesSupported []string
+		scopeMappings   []api.OAuthProxyScopeMappingConfig
+		claims          map[string]any
+		requestedScope  string
+		upstreamScope   string
+		wantScope       string
+	}{
+		{"requested scopes granted by the IdP", nil, nil, nil, "mcp:read mcp:write", "openid mcp:read", "mcp:read"},
+		{"requested scopes when the IdP omits them", nil, nil, nil, "mcp:read mcp:write", "", "mcp:read mcp:write"},
+		{"unsupported scopes are dropped", []string{"mcp:read"}, nil, nil, "mcp:read admin", "openid mcp:read admin", "mcp:read"},
+		{"scopes of the claims of the user", nil, writersMapping, map[string]any{"groups": []string{"writers"}}, "mcp:read mcp:write", "openid mcp:read", "mcp:write"},
+		{"scopes of a single claim value", nil, writersMapping, map[string]any{"groups": "writers"}, "mcp:write", "", "mcp:write"},
+		{"user without mapped claims", nil, writersMapping, map[string]any{"groups": []string{"readers"}}, "mcp:write", "mcp:write", ""},
+		{"every mapped scope when none is requested", nil, writersMapping, map[string]any{"groups": []string{"writers"}}, "", "", "mcp:write"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hm, idp := newTestOAuthProxyFlow(t)
+			hm.dependencies.AppCtx.Config.OAuthProtectedResource.ScopesSupported = test.scopesSupported
+			hm.dependencies.AppCtx.Config.OAuthAuthorizationServer.Proxy.ScopeMappings = test.scopeMappings
+			idp.claims = test.claims
+			idp.scope = test.upstreamScope
+
+			query := getTestClientRedirect(t, loginTestUser(t, hm, testPublicClientID, test.requestedScope))
+			if tokenResponse := redeemTestCode(t, hm, query.Get("code"), testPublicClientID); tokenResponse.Scope != test.wantScope {
+				t.Errorf("got scope %q, want %q", tokenResponse.Scope, test.wantScope)
+			}
+		})
+	}
+}
+
+func TestOAuthProxyFlowIDToken(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		signingKey  *rsa.PrivateKey
+		modifyToken func(claims jwt.MapClaims)
+		valid       bool
+	}{
+		{"valid token", nil, func(claims jwt.MapClaims) {}, true},
+		{"several audiences issued to the proxy", nil, func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testUpstreamClient, "other"}
+			claims["azp"] = testUpstreamClient
+		}, true},
+
+		{"signed with another key", otherKey, func(claims jwt.MapClaims) {}, false},
+		{"another issuer", nil, func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }, false},
+		{"another audience", nil, func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, false},
+		{"several audiences issued to another party", nil, func(claims jwt.MapClaims) {
+			claims["aud"] = []string{testUpstreamClient, "other"}
+			claims["azp"] = "other"
+		}, false},
+		{"another nonce", nil, func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" }, false},
+		{"missing nonce", nil, func(claims jwt.MapClaims) { delete(claims, "nonce") }, false},
+		{"expired", nil, func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }, false},
+		{"missing expiration", nil, func(claims jwt.MapClaims) { delete(claims, "exp") }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hm, idp := newTestOAuthProxyFlow(t)
+			idp.signingKey = test.signingKey
+			idp.modifyToken = test.modifyToken
+
+			query := getTestClientRedirect(t, loginTestUser(t, hm, testPublicClientID, ""))
+			if valid := query.Get("code") != ""; valid != test.valid {
+				t.Errorf("got code = %v (error %q), want %v", valid, query.Get("error"), test.valid)
+			}
+			if !test.valid && query.Get("error") != OAuthErrorServerError {
+				t.Errorf("got error %q, want %q", query.Get("error"), OAuthErrorServerError)
+			}
+		})
+	}
+}
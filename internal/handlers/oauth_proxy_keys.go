@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oauthProxySigner signs the tokens issued by the built-in authorization server
+type oauthProxySigner struct {
+	key           crypto.Signer
+	signingMethod jwt.SigningMethod
+	kid           string
+
+	// JWKS with the public key, as published
+	jwks []byte
+}
+
+// newOAuthProxySigner loads the signing key from a PEM file, or generates an ephemeral one when it is empty
+func newOAuthProxySigner(keyFile string) (*oauthProxySigner, error) {
+	var key crypto.Signer
+	var err error
+
+	if keyFile == "" {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed generating signing key: %s", err.Error())
+		}
+	} else {
+		key, err = loadSigningKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding public key: %s", err.Error())
+	}
+	publicKeyHash := sha256.Sum256(publicKeyBytes)
+
+	signer := &oauthProxySigner{
+		key: key,
+		kid: base64.RawURLEncoding.EncodeToString(publicKeyHash[:]),
+	}
+
+	jwk, err := signer.getPublicJWK()
+	if err != nil {
+		return nil, err
+	}
+
+	signer.jwks, err = json.Marshal(map[string]any{"keys": []any{jwk}})
+	if err != nil {
+		return nil, fmt.Errorf("failed encoding JWKS: %s", err.Error())
+	}
+
+	return signer, nil
+}
+
+// Sign creates a JWT with the claims, signed with the key
+func (s *oauthProxySigner) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(s.signingMethod, claims)
+	token.Header["kid"] = s.kid
+
+	return token.SignedString(s.key)
+}
+
+// getPublicJWK returns the public key as a JWK, choosing the signing method for its type
+func (s *oauthProxySigner) getPublicJWK() (map[string]any, error) {
+	jwk := map[string]any{
+		"kid": s.kid,
+		"use": "sig",
+	}
+
+	switch publicKey := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		s.signingMethod = jwt.SigningMethodRS256
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		switch publicKey.Curve {
+		case elliptic.P256():
+			s.signingMethod = jwt.SigningMethodES256
+		case elliptic.P384():
+			s.signingMethod = jwt.SigningMethodES384
+		case elliptic.P521():
+			s.signingMethod = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported signing key curve")
+		}
+
+		ecdhKey, err := publicKey.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("failed encoding public key: %s", err.Error())
+		}
+
+		// Uncompressed point: 0x04 || X || Y
+		point := ecdhKey.Bytes()[1:]
+		jwk["kty"] = "EC"
+		jwk["crv"] = publicKey.Curve.Params().Name
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(point[:len(point)/2])
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(point[len(point)/2:])
+
+	case ed25519.PublicKey:
+		s.signingMethod = jwt.SigningMethodEdDSA
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+
+	default:
+		return nil, fmt.Errorf("unsupported signing key type")
+	}
+
+	jwk["alg"] = s.signingMethod.Alg()
+	return jwk, nil
+}
+
+// loadSigningKey reads a private key from a PEM file. PKCS#8, PKCS#1 and SEC 1 encodings are supported
+func loadSigningKey(keyFile string) (crypto.Signer, error) {
+	keyBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading signing key: %s", err.Error())
+	}
+
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("signing key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing signing key: %s", err.Error())
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type")
+	}
+	return signer, nil
+}
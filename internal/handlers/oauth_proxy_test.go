@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"mcp-proxy/api"
+	"mcp-proxy/internal/globals"
+)
+
+const (
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	testPublicClientID       = "mcp-public"
+	testConfidentialClientID = "mcp-confidential"
+	testClientSecret         = "s3cr3t"
+	testRedirectUri          = "http://localhost:8080/callback"
+)
+
+// newTestOAuthProxyHandlers returns a handlers manager with the built-in authorization server enabled,
+// knowing a public and a confidential pre-provisioned client
+func newTestOAuthProxyHandlers(t *testing.T) *HandlersManager {
+	t.Helper()
+
+	config := &api.Configuration{}
+	config.Middleware.JWT.Enabled = true
+	config.Middleware.JWT.Validation.Strategy = "local"
+	config.OAuthProtectedResource.Resource = "https://mcp-proxy.example.com/mcp"
+	config.OAuthAuthorizationServer.Enabled = true
+	config.OAuthAuthorizationServer.Proxy.Enabled = true
+	config.OAuthAuthorizationServer.Proxy.TokenTTL = time.Minute
+	config.OAuthAuthorizationServer.ClientRegistration.AllowedRedirectUris = []string{"http://localhost:*/*"}
+	config.OAuthAuthorizationServer.ClientRegistration.Clients = []api.OAuthStaticClientConfig{
+		{ClientID: testPublicClientID},
+		{ClientID: testConfidentialClientID, ClientSecret: testClientSecret, RedirectUris: []string{"https://app.example.com/*"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hm, err := NewHandlersManager(HandlersManagerDependencies{
+		AppCtx: &globals.ApplicationContext{
+			Context: ctx,
+			Cancel:  cancel,
+			Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Config:  config,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed creating handlers: %v", err)
+	}
+	return hm
+}
+
+// putTestGrant stores an authorization code for a client, as the callback does after the login in the IdP
+func putTestGrant(hm *HandlersManager, code, clientID, redirectUri string) {
+	challenge := sha256.Sum256([]byte(testCodeVerifier))
+	hm.oauthProxy.codes.Put(code, oauthProxyGrant{
+		oauthProxyTransaction: oauthProxyTransaction{
+			ClientID:      clientID,
+			RedirectUri:   redirectUri,
+			CodeChallenge: base64.RawURLEncoding.EncodeToString(challenge[:]),
+		},
+		Claims: map[string]any{"sub": "user"},
+	}, oauthProxyCodeTTL)
+}
+
+// requestTestToken sends a request to '/token', returning the status code and the OAuth error, when there is one
+func requestTestToken(t *testing.T, hm *HandlersManager, form url.Values, basicAuth []string) (int, string) {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, OAuthProxyTokenPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicAuth != nil {
+		request.SetBasicAuth(basicAuth[0], basicAuth[1])
+	}
+
+	recorder := httptest.NewRecorder()
+	hm.HandleOauthProxyToken(recorder, request)
+
+	if recorder.Code == http.StatusOK {
+		return recorder.Code, ""
+	}
+
+	errorResponse := OAuthErrorResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("error response is not JSON: %v", err)
+	}
+	return recorder.Code, errorResponse.Error
+}
+
+func getTestTokenForm(code, clientID, verifier string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {clientID},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {verifier},
+	}
+}
+
+func TestOAuthProxyTokenPKCE(t *testing.T) {
+	tests := []struct {
+		name       string
+		verifier   string
+		statusCode int
+		errorCode  string
+	}{
+		{"matching verifier", testCodeVerifier, http.StatusOK, ""},
+		{"other verifier", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXx", http.StatusBadRequest, OAuthErrorInvalidGrant},
+		{"challenge used as verifier", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", http.StatusBadRequest, OAuthErrorInvalidGrant},
+		{"missing verifier", "", http.StatusBadRequest, OAuthErrorInvalidGrant},
+	}
+
+	hm := newTestOAuthProxyHandlers(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			putTestGrant(hm, "code", testPublicClientID, testRedirectUri)
+
+			statusCode, errorCode := requestTestToken(t, hm, getTestTokenForm("code", testPublicClientID, test.verifier), nil)
+			if statusCode != test.statusCode || errorCode != test.errorCode {
+				t.Errorf("got %d %q, want %d %q", statusCode, errorCode, test.statusCode, test.errorCode)
+			}
+		})
+	}
+}
+
+func TestOAuthProxyTokenCodeRedemption(t *testing.T) {
+	type attempt struct {
+		form       url.Values
+		statusCode int
+	}
+
+	otherRedirectUri := getTestTokenForm("code", testPublicClientID, testCodeVerifier)
+	otherRedirectUri.Set("redirect_uri", "http://localhost:9090/callback")
+
+	tests := []struct {
+		name     string
+		attempts []attempt
+	}{
+		{"code is redeemed once", []attempt{
+			{getTestTokenForm("code", testPublicClientID, testCodeVerifier), http.StatusOK},
+			{getTestTokenForm("code", testPublicClientID, testCodeVerifier), http.StatusBadRequest},
+		}},
+		{"failed redemption consumes the code", []attempt{
+			{getTestTokenForm("code", testPublicClientID, "wrong"), http.StatusBadRequest},
+			{getTestTokenForm("code", testPublicClientID, testCodeVerifier), http.StatusBadRequest},
+		}},
+		{"code of another client", []attempt{
+			{getTestTokenForm("code", "mcp-other", testCodeVerifier), http.StatusBadRequest},
+		}},
+		{"other redirect URI", []attempt{
+			{otherRedirectUri, http.StatusBadRequest},
+		}},
+		{"unknown code", []attempt{
+			{getTestTokenForm("unknown", testPublicClientID, testCodeVerifier), http.StatusBadRequest},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hm := newTestOAuthProxyHandlers(t)
+			putTestGrant(hm, "code", testPublicClientID, testRedirectUri)
+
+			for i, attempt := range test.attempts {
+				if statusCode, _ := requestTestToken(t, hm, attempt.form, nil); statusCode != attempt.statusCode {
+					t.Errorf("attempt %d: got %d, want %d", i+1, statusCode, attempt.statusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestOAuthProxyTokenClientAuthentication(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientID     string
+		clientSecret string
+		basicAuth    bool
+		statusCode   int
+		errorCode    string
+	}{
+		{"confidential client with basic auth", testConfidentialClientID, testClientSecret, true, http.StatusOK, ""},
+		{"confidential client with form secret", testConfidentialClientID, testClientSecret, false, http.StatusOK, ""},
+		{"confidential client with wrong secret", testConfidentialClientID, "wrong", true, http.StatusUnauthorized, OAuthErrorInvalidClient},
+		{"confidential client without secret", testConfidentialClientID, "", false, http.StatusUnauthorized, OAuthErrorInvalidClient},
+		{"public client authenticated by PKCE", testPublicClientID, "", false, http.StatusOK, ""},
+		{"missing client", "", "", false, http.StatusUnauthorized, OAuthErrorInvalidClient},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hm := newTestOAuthProxyHandlers(t)
+			putTestGrant(hm, "code", test.clientID, testRedirectUri)
+
+			form := getTestTokenForm("code", test.clientID, testCodeVerifier)
+			var basicAuth []string
+			if test.basicAuth {
+				form.Del("client_id")
+				basicAuth = []string{test.clientID, test.clientSecret}
+			} else if test.clientSecret != "" {
+				form.Set("client_secret", test.clientSecret)
+			}
+
+			statusCode, errorCode := requestTestToken(t, hm, form, basicAuth)
+			if statusCode != test.statusCode || errorCode != test.errorCode {
+				t.Errorf("got %d %q, want %d %q", statusCode, errorCode, test.statusCode, test.errorCode)
+			}
+		})
+	}
+}
+
+func TestIsOAuthProxyClientAllowed(t *testing.T) {
+	hm := newTestOAuthProxyHandlers(t)
+	hm.oauthProxy.clients.Put("registered", oauthProxyClient{RedirectUris: []string{testRedirectUri}}, time.Minute)
+
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectUri string
+		allowed     bool
+	}{
+		{"registered client with its redirect URI", "registered", testRedirectUri, true},
+		{"registered client with another allowed redirect URI", "registered", "http://localhost:9090/callback", false},
+		{"pre-provisioned client with policy patterns", testPublicClientID, "http://localhost:9090/callback", true},
+		{"pre-provisioned client with its own patterns", testConfidentialClientID, "https://app.example.com/callback", true},
+		{"pre-provisioned client outside its own patterns", testConfidentialClientID, testRedirectUri, false},
+		{"pre-provisioned client with user info", testPublicClientID, "http://localhost:1@evil.com/callback", false},
+		{"unknown client", "unknown", testRedirectUri, false},
+		{"missing client", "", testRedirectUri, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := hm.isOAuthProxyClientAllowed(test.clientID, test.redirectUri); allowed != test.allowed {
+				t.Errorf("isOAuthProxyClientAllowed(%q, %q) = %v, want %v",
+					test.clientID, test.redirectUri, allowed, test.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"net/http"
+
+	//
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	//
+	"mcp-proxy/internal/cache"
+)
+
+const namespace = "mcp_proxy"
+
+// Registry holds the metrics of the proxy, along with the ones of the Go runtime and the process
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTP requests served, labeled by path (only registered ones), method and status code
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served by the proxy.",
+	}, []string{"path", "method", "code"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent serving HTTP requests. Streams are measured until they are closed.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path", "method"})
+
+	// Tool calls forwarded to the backend. Status values: 'ok', 'tool_error', 'backend_error' or 'cached'
+	ToolCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tool_calls_total",
+		Help:      "Tool calls handled by the proxy, by backend tool and status.",
+	}, []string{"tool", "status"})
+
+	ToolCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tool_call_duration_seconds",
+		Help:      "Time spent by the backend executing tool calls.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"tool"})
+
+	// Cache lookups. Cache values: 'results' (memoized tool results) or 'responses' (big responses for 'read_cache')
+	CacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups, by cache and result ('hit' or 'miss').",
+	}, []string{"cache", "result"})
+
+	// Authentication outcomes of the JWT validation middleware. Outcome values: 'allowed' or 'denied'
+	AuthRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_requests_total",
+		Help:      "Requests checked by the JWT validation middleware, by strategy, outcome and reason.",
+	}, []string{"strategy", "outcome", "reason"})
+
+	// Attempts to connect to the backend. Every attempt after the first successful one is a reconnection
+	BackendConnectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_connections_total",
+		Help:      "Attempts to connect to the backend MCP server, by result ('success' or 'error').",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		ToolCallsTotal,
+		ToolCallDuration,
+		CacheLookupsTotal,
+		AuthRequestsTotal,
+		BackendConnectionsTotal,
+	)
+}
+
+// RegisterCaches exposes the size of the caches, computed when metrics are scraped
+func RegisterCaches(responsesCache *cache.Cache, resultsCache *cache.ResultsCache) {
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_entries",
+			Help:        "Entries stored in the cache.",
+			ConstLabels: prometheus.Labels{"cache": "responses"},
+		}, func() float64 {
+			entries, _ := responsesCache.Stats()
+			return float64(entries)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_bytes",
+			Help:        "Size of the JSON encoded entries stored in the cache.",
+			ConstLabels: prometheus.Labels{"cache": "responses"},
+		}, func() float64 {
+			_, bytes := responsesCache.Stats()
+			return float64(bytes)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_entries",
+			Help:        "Entries stored in the cache.",
+			ConstLabels: prometheus.Labels{"cache": "results"},
+		}, func() float64 {
+			entries, _ := resultsCache.Stats()
+			return float64(entries)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "cache_bytes",
+			Help:        "Size of the JSON encoded entries stored in the cache.",
+			ConstLabels: prometheus.Labels{"cache": "results"},
+		}, func() float64 {
+			_, bytes := resultsCache.Stats()
+			return float64(bytes)
+		}),
+	)
+}
+
+// Handler serves the metrics in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -30,7 +30,12 @@ func (mw *CORSMiddleware) Middleware(next http.Handler) http.Handler {
 		// Methods/headers per endpoint
 		if req.URL.Path == "/mcp" {
 			rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, mcp-protocol-version")
+			rw.Header().Set("Access-Control-Allow-Headers", "Authorization, DPoP, Content-Type, mcp-protocol-version")
+			// Browser clients need the challenges to discover the authorization server
+			rw.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate")
+		} else if req.URL.Path == "/register" || req.URL.Path == "/token" {
+			rw.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			rw.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		} else {
 			rw.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 			rw.Header().Set("Access-Control-Allow-Headers", "Content-Type, mcp-protocol-version")
@@ -1,45 +1,63 @@
 package middlewares
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 
 	//
+	"mcp-proxy/api"
 	"mcp-proxy/internal/globals"
+	"mcp-proxy/internal/metrics"
 
 	//
 	"github.com/google/cel-go/cel"
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
+// jwtPayloadContextKey is the context key for storing the payload of the validated JWT
+type jwtPayloadContextKey struct{}
+
+// JWTPayloadFromContext retrieves the payload of the validated JWT from context, when present
+func JWTPayloadFromContext(ctx context.Context) (map[string]any, bool) {
+	payload, ok := ctx.Value(jwtPayloadContextKey{}).(map[string]any)
+	return payload, ok
+}
+
 type JWTValidationMiddlewareDependencies struct {
 	AppCtx *globals.ApplicationContext
+
+	// GetBackendTool returns the definition of a backend tool, used to enforce scopes by tool annotations
+	GetBackendTool func(ctx context.Context, name string) (mcp.Tool, bool, error)
+
+	// ExtraIssuers are trusted by the local JWT validation besides the configured ones, such as the built-in authorization server
+	ExtraIssuers []api.JWTValidationIssuerConfig
 }
 
 type JWTValidationMiddleware struct {
 	dependencies JWTValidationMiddlewareDependencies
 
 	// Carried stuff
-	jwks  *JWKS
-	mutex sync.Mutex
+	trustedIssuers []*trustedIssuer
 
 	//
-	celPrograms []*cel.Program
+	celPrograms         []*cel.Program
+	introspectionCache  *introspectionCache
+	introspectionClient *http.Client
+	dpopReplayCache     *dpopReplayCache
+	apiKeyStore         *apiKeyStore
 }
 
 func NewJWTValidationMiddleware(deps JWTValidationMiddlewareDependencies) (*JWTValidationMiddleware, error) {
 
 	mw := &JWTValidationMiddleware{
-		dependencies: deps,
-	}
-
-	// Launch JWKS worker only when requested
-	if mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled &&
-		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy == "local" {
-		go mw.cacheJWKS()
+		dependencies:       deps,
+		introspectionCache: newIntrospectionCache(),
+		dpopReplayCache:    newDPoPReplayCache(),
 	}
 
 	// Precompile and check CEL expressions to fail-fast and safe resources.
@@ -51,28 +69,112 @@ func NewJWTValidationMiddleware(deps JWTValidationMiddlewareDependencies) (*JWTV
 		return nil, fmt.Errorf("CEL environment creation error: %s", err.Error())
 	}
 
-	for _, allowCondition := range mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.AllowConditions {
+	allowConditions := append([]api.JWTValidationAllowCondition{},
+		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.AllowConditions...)
+	allowConditions = append(allowConditions, mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.AllowConditions...)
+
+	mw.celPrograms, err = compileAllowConditions(allowConditionsEnv, allowConditions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Launch JWKS workers only when requested.
+	// First keys are loaded before serving, so early tokens are not rejected
+	if mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled &&
+		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy == "local" {
+
+		issuersConfig := append(getTrustedIssuersConfig(mw.dependencies.AppCtx.Config), mw.dependencies.ExtraIssuers...)
+		for _, issuerConfig := range issuersConfig {
+			issuer := &trustedIssuer{
+				config: issuerConfig,
+				jwksProvider: newJWKSProvider(issuerConfig.JWTValidationJWKSConfig, getDiscoveryUri(issuerConfig),
+					mw.dependencies.AppCtx.Logger.With("issuer", issuerConfig.Issuer)),
+			}
+
+			issuer.celPrograms, err = compileAllowConditions(allowConditionsEnv, issuerConfig.AllowConditions)
+			if err != nil {
+				return nil, err
+			}
+
+			issuer.jwksProvider.Run(mw.dependencies.AppCtx.Context)
+			mw.trustedIssuers = append(mw.trustedIssuers, issuer)
+		}
+	}
+
+	// Introspection settings are checked before serving, as every request would fail otherwise
+	if mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled &&
+		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy == "introspection" {
+
+		introspectionConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Introspection
+		if introspectionConfig.Endpoint == "" {
+			return nil, fmt.Errorf("introspection strategy requires 'introspection.endpoint'")
+		}
+
+		if len(getIntrospectionAudiences(mw.dependencies.AppCtx.Config)) == 0 {
+			return nil, fmt.Errorf("introspection strategy requires 'introspection.audiences' or 'oauth_protected_resource.resource'")
+		}
+
+		mw.introspectionClient = &http.Client{Timeout: introspectionConfig.Timeout}
+	}
+
+	// Keys are loaded before serving, failing when they are not valid.
+	// They are reloaded from the file in background, so keys can be rotated without restarting
+	if mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled &&
+		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy == "api_key" {
+
+		mw.apiKeyStore, err = newAPIKeyStore(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.APIKey,
+			mw.dependencies.AppCtx.Logger)
+		if err != nil {
+			return nil, err
+		}
+
+		mw.apiKeyStore.Run(mw.dependencies.AppCtx.Context)
+	}
+
+	return mw, nil
+}
+
+// recordAuthOutcome counts the outcome of the validation of a request, and the reason of denials
+func (mw *JWTValidationMiddleware) recordAuthOutcome(outcome, reason string) {
+	strategy := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy
+	if strategy == "" {
+		strategy = "external"
+	}
+	metrics.AuthRequestsTotal.WithLabelValues(strategy, outcome, reason).Inc()
+}
+
+// compileAllowConditions compiles the CEL expressions of the allowance conditions into programs
+func compileAllowConditions(env *cel.Env, allowConditions []api.JWTValidationAllowCondition) ([]*cel.Program, error) {
+	celPrograms := []*cel.Program{}
+	for _, allowCondition := range allowConditions {
 
 		// Compile and execute the code
-		ast, issues := allowConditionsEnv.Compile(allowCondition.Expression)
+		ast, issues := env.Compile(allowCondition.Expression)
 		if issues != nil && issues.Err() != nil {
 			return nil, fmt.Errorf("CEL expression compilation exited with error: %s", issues.Err())
 		}
 
-		prg, err := allowConditionsEnv.Program(ast)
+		prg, err := env.Program(ast)
 		if err != nil {
 			return nil, fmt.Errorf("CEL program construction error: %s", err.Error())
 		}
-		mw.celPrograms = append(mw.celPrograms, &prg)
+		celPrograms = append(celPrograms, &prg)
 	}
 
-	return mw, nil
+	return celPrograms, nil
 }
 
 func (mw *JWTValidationMiddleware) Middleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 
+		var tokenPayload map[string]any
+		var requiredScopes []string
+		var err error
+		var celPrograms []*cel.Program
+		var accessToken, authScheme string
+		var found bool
+
 		if !mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled {
 			goto nextStage
 		}
@@ -80,67 +182,183 @@ func (mw *JWTValidationMiddleware) Middleware(next http.Handler) http.Handler {
 		switch mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy {
 		case "local":
 			// 1. Extract token from header
-			authHeader := req.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(rw, "RBAC: Access Denied: Authorization header not found", http.StatusUnauthorized)
+			accessToken, authScheme, found = mw.getAccessToken(req)
+			if !found {
+				mw.recordAuthOutcome("denied", "missing_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, "", "Bearer token not found")
 				return
 			}
-			tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 
 			// Reject unauthorized requests
-			_, err := mw.isTokenValid(tokenString)
+			issuer, err := mw.validateToken(accessToken)
 			if err != nil {
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Invalid token: %v", err.Error()), http.StatusUnauthorized)
+				mw.dependencies.AppCtx.Logger.Debug("token validation failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "invalid_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "Invalid token")
 				return
 			}
 
+			// Tokens must also meet the conditions of their issuer
+			celPrograms = issuer.celPrograms
+
 			// Put the JWT into the validated request header
-			req.Header.Set(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader, tokenString)
+			req.Header.Set(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader, accessToken)
 
 			// Extract the JWT payload
-			tokenStringParts := strings.Split(tokenString, ".")
+			tokenStringParts := strings.Split(accessToken, ".")
 
 			// Decode it into a Go's structure for later
 			tokenPayloadBytes, err := base64.RawURLEncoding.DecodeString(tokenStringParts[1])
 			if err != nil {
 				mw.dependencies.AppCtx.Logger.Error("error decoding JWT payload from base64", "error", err.Error())
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: JWT Payload can not be decoded"), http.StatusUnauthorized)
+				mw.recordAuthOutcome("denied", "invalid_payload")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "JWT Payload can not be decoded")
 				return
 			}
 
-			tokenPayload := map[string]any{}
 			err = json.Unmarshal(tokenPayloadBytes, &tokenPayload)
 			if err != nil {
 				mw.dependencies.AppCtx.Logger.Error("error decoding JWT payload from JSON", "error", err.Error())
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Internal Issue"), http.StatusUnauthorized)
+				mw.recordAuthOutcome("denied", "invalid_payload")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "JWT Payload can not be decoded")
 				return
 			}
 
-			// Check allowance conditions for the JWT
-			// At this point, we assume the JWT is unmarshalled into a golang structure
-			for _, celProgram := range mw.celPrograms {
-				out, _, err := (*celProgram).Eval(map[string]interface{}{
-					"payload": tokenPayload,
-				})
+		case "introspection":
+			accessToken, authScheme, found = mw.getAccessToken(req)
+			if !found {
+				mw.recordAuthOutcome("denied", "missing_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, "", "Bearer token not found")
+				return
+			}
 
-				if err != nil {
-					mw.dependencies.AppCtx.Logger.Error("CEL program evaluation error", "error", err.Error())
-					http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Internal Issue"), http.StatusUnauthorized)
-					return
-				}
+			// Reject inactive tokens. Returned claims are the payload for later
+			tokenPayload, err = mw.introspectToken(accessToken)
+			if errors.Is(err, errTokenValidationUnavailable) {
+				mw.dependencies.AppCtx.Logger.Error("token introspection failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "validation_unavailable")
+				http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Debug("token validation failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "invalid_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "Invalid token")
+				return
+			}
 
-				if out.Value() != true {
-					http.Error(rw, fmt.Sprintf("RBAC: Access Denied: JWT does not meet conditions"), http.StatusUnauthorized)
-					return
-				}
+		case "api_key":
+			apiKey, apiKeyFound := mw.getAPIKey(req)
+			if !apiKeyFound {
+				mw.recordAuthOutcome("denied", "missing_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, "", "API key not found")
+				return
 			}
 
+			// Key metadata is the payload for later, so the same conditions apply to keys and tokens
+			keyConfig, err := mw.apiKeyStore.Lookup(apiKey)
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Debug("API key validation failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "invalid_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "Invalid API key")
+				return
+			}
+			tokenPayload = getAPIKeyPayload(keyConfig)
+
 		default:
 			// Having a validated JWT into a specific header is the default behavior,
 			// as having tools like Istio securing APIs is much more safe and reliable
-			// When the token is already validated, do nothing.
+			// When the token is already validated, just decode its payload for the allowance conditions
+			forwardedHeader := req.Header.Get(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader)
+			if forwardedHeader == "" {
+				mw.recordAuthOutcome("denied", "missing_token")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, "", "Validated JWT header not found")
+				return
+			}
+
+			tokenPayload, err = parseForwardedPayload(forwardedHeader)
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Error("error decoding forwarded JWT payload", "error", err.Error())
+				mw.recordAuthOutcome("denied", "invalid_payload")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "JWT Payload can not be decoded")
+				return
+			}
+		}
+
+		// Every strategy produces a payload. Requests without one are denied, so the checks below are never skipped
+		if len(tokenPayload) == 0 {
+			mw.recordAuthOutcome("denied", "invalid_payload")
+			mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "JWT Payload can not be decoded")
+			return
+		}
+
+		// Sender-constrained tokens are only valid along with the proof of possession of their key
+		if accessToken != "" {
+			err := mw.checkDPoP(req, authScheme, accessToken, tokenPayload)
+			if errors.Is(err, errInvalidDPoPProof) {
+				mw.dependencies.AppCtx.Logger.Debug("DPoP proof validation failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "invalid_dpop_proof")
+				mw.denyDPoPRequest(rw, "Invalid DPoP proof")
+				return
+			}
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Debug("DPoP binding validation failed", "error", err.Error())
+				mw.recordAuthOutcome("denied", "dpop_binding")
+				mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "Invalid token")
+				return
+			}
 		}
 
+		// Certificate-bound tokens are only valid over connections authenticated with their certificate
+		if err := mw.checkCertificateBinding(req, tokenPayload); err != nil {
+			mw.dependencies.AppCtx.Logger.Debug("certificate binding validation failed", "error", err.Error())
+			mw.recordAuthOutcome("denied", "certificate_binding")
+			mw.denyRequest(rw, req, http.StatusUnauthorized, BearerErrorInvalidToken, "Invalid token")
+			return
+		}
+
+		// Check allowance conditions for the token
+		// At this point, we assume the payload is unmarshalled into a golang structure.
+		// Evaluation errors are denials too, as they are mostly caused by missing claims
+		for _, celProgram := range append(append([]*cel.Program{}, mw.celPrograms...), celPrograms...) {
+			out, _, err := (*celProgram).Eval(map[string]interface{}{
+				"payload": tokenPayload,
+			})
+
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Warn("CEL program evaluation error", "error", err.Error())
+			}
+
+			// No error code is sent, as asking the user for other scopes would not help.
+			// 'insufficient_scope' is kept for the scope checks, so clients only step up when it can succeed
+			if err != nil || out.Value() != true {
+				mw.recordAuthOutcome("denied", "conditions")
+				mw.denyRequest(rw, req, http.StatusForbidden, "", "Token does not meet conditions")
+				return
+			}
+		}
+
+		// Check the token grants the scopes needed by the requested methods and tools
+		requiredScopes, err = mw.getRequiredScopes(rw, req)
+		if err != nil {
+			mw.dependencies.AppCtx.Logger.Debug("error deciding required scopes", "error", err.Error())
+			mw.recordAuthOutcome("denied", "bad_request")
+			http.Error(rw, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if len(getMissingScopes(requiredScopes, getTokenScopes(tokenPayload))) > 0 {
+			mw.recordAuthOutcome("denied", "insufficient_scope")
+			mw.denyRequestWithScopes(rw, req, http.StatusForbidden, BearerErrorInsufficientScope,
+				"Token does not have the required scopes", requiredScopes)
+			return
+		}
+
+		mw.recordAuthOutcome("allowed", "")
+
+		// Expose the payload to the next stages, such as MCP handlers
+		req = req.WithContext(context.WithValue(req.Context(), jwtPayloadContextKey{}, tokenPayload))
+
 	nextStage:
 		next.ServeHTTP(rw, req)
 	})
@@ -0,0 +1,196 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-proxy/api"
+
+	//
+	"gopkg.in/yaml.v3"
+)
+
+// apiKeysFile represents the content of the file with the API keys
+type apiKeysFile struct {
+	Keys []api.JWTValidationAPIKeyConfig `yaml:"keys"`
+}
+
+// apiKeyStore keeps the accepted API keys, indexed by their hash.
+// Last loaded keys are kept when reloading fails, so a half-written file does not reject every request
+type apiKeyStore struct {
+	config api.JWTValidationAPIKeysConfig
+	logger *slog.Logger
+
+	mutex       sync.RWMutex
+	keys        map[string]api.JWTValidationAPIKeyConfig
+	fileModTime time.Time
+}
+
+// newAPIKeyStore loads the API keys, failing when they are not valid
+func newAPIKeyStore(config api.JWTValidationAPIKeysConfig, logger *slog.Logger) (*apiKeyStore, error) {
+	store := &apiKeyStore{
+		config: config,
+		logger: logger,
+	}
+
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Run checks the keys file for changes from time to time, until the context is done
+func (s *apiKeyStore) Run(ctx context.Context) {
+	if s.config.KeysFile == "" {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.ReloadInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fileInfo, err := os.Stat(s.config.KeysFile)
+				if err != nil {
+					s.logger.Warn("failed checking API keys file for changes", "error", err.Error())
+					continue
+				}
+
+				s.mutex.RLock()
+				changed := !fileInfo.ModTime().Equal(s.fileModTime)
+				s.mutex.RUnlock()
+
+				if !changed {
+					continue
+				}
+
+				if err := s.reload(); err != nil {
+					s.logger.Error("failed reloading API keys, keeping the loaded ones", "error", err.Error())
+					continue
+				}
+				s.logger.Info("API keys reloaded")
+			}
+		}
+	}()
+}
+
+// Lookup returns the configuration of an API key, when it is known and not expired
+func (s *apiKeyStore) Lookup(key string) (api.JWTValidationAPIKeyConfig, error) {
+	keyHash := sha256.Sum256([]byte(key))
+
+	s.mutex.RLock()
+	keyConfig, found := s.keys[hex.EncodeToString(keyHash[:])]
+	s.mutex.RUnlock()
+
+	if !found {
+		return keyConfig, fmt.Errorf("unknown API key")
+	}
+
+	if !keyConfig.ExpiresAt.IsZero() && time.Now().After(keyConfig.ExpiresAt) {
+		return keyConfig, fmt.Errorf("API key '%s' is expired", keyConfig.Name)
+	}
+
+	return keyConfig, nil
+}
+
+// reload loads the inline keys and the ones from the file
+func (s *apiKeyStore) reload() error {
+	keysConfig := append([]api.JWTValidationAPIKeyConfig{}, s.config.Keys...)
+
+	var fileModTime time.Time
+	if s.config.KeysFile != "" {
+		fileInfo, err := os.Stat(s.config.KeysFile)
+		if err != nil {
+			return fmt.Errorf("failed reading API keys file: %s", err.Error())
+		}
+		fileModTime = fileInfo.ModTime()
+
+		fileBytes, err := os.ReadFile(s.config.KeysFile)
+		if err != nil {
+			return fmt.Errorf("failed reading API keys file: %s", err.Error())
+		}
+
+		var keysFile apiKeysFile
+		if err := yaml.Unmarshal(fileBytes, &keysFile); err != nil {
+			return fmt.Errorf("failed decoding API keys file: %s", err.Error())
+		}
+		keysConfig = append(keysConfig, keysFile.Keys...)
+	}
+
+	keys := make(map[string]api.JWTValidationAPIKeyConfig, len(keysConfig))
+	for _, keyConfig := range keysConfig {
+		keyHash := strings.ToLower(strings.TrimPrefix(keyConfig.Hash, "sha256:"))
+
+		hashBytes, err := hex.DecodeString(keyHash)
+		if err != nil || len(hashBytes) != sha256.Size {
+			return fmt.Errorf("API key '%s' has an invalid SHA-256 hash", keyConfig.Name)
+		}
+
+		if _, found := keys[keyHash]; found {
+			return fmt.Errorf("API key '%s' is duplicated", keyConfig.Name)
+		}
+		keys[keyHash] = keyConfig
+	}
+
+	s.mutex.Lock()
+	s.keys = keys
+	s.fileModTime = fileModTime
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// getAPIKey extracts the API key from the configured header, or from the 'Authorization' header otherwise
+func (mw *JWTValidationMiddleware) getAPIKey(req *http.Request) (string, bool) {
+	if header := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.APIKey.Header; header != "" {
+		key := strings.TrimSpace(req.Header.Get(header))
+		return key, key != ""
+	}
+
+	authHeader := req.Header.Get("Authorization")
+	scheme, key, found := strings.Cut(authHeader, " ")
+	if !found || !strings.EqualFold(scheme, AuthSchemeBearer) {
+		return "", false
+	}
+
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// getAPIKeyPayload returns the payload exposed to the next stages for an API key, as if it was a token
+func getAPIKeyPayload(keyConfig api.JWTValidationAPIKeyConfig) map[string]any {
+	groups := make([]any, 0, len(keyConfig.Groups))
+	for _, group := range keyConfig.Groups {
+		groups = append(groups, group)
+	}
+
+	payload := map[string]any{
+		"sub":    keyConfig.Name,
+		"name":   keyConfig.Name,
+		"groups": groups,
+	}
+
+	if len(keyConfig.Scopes) > 0 {
+		payload["scope"] = strings.Join(keyConfig.Scopes, " ")
+	}
+
+	if !keyConfig.ExpiresAt.IsZero() {
+		payload["exp"] = float64(keyConfig.ExpiresAt.Unix())
+	}
+
+	return payload
+}
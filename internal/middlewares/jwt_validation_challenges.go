@@ -0,0 +1,106 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	//
+	"mcp-proxy/api"
+)
+
+// Error codes for Bearer token challenges
+// Ref: https://datatracker.ietf.org/doc/html/rfc6750#section-3.1
+const (
+	BearerErrorInvalidRequest    = "invalid_request"
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// errTokenValidationUnavailable marks validation failures not caused by the token itself,
+// such as an unreachable introspection endpoint. Clients must not be asked to authorize again on them
+var errTokenValidationUnavailable = errors.New("token validation is unavailable")
+
+// denyRequest rejects a request with a Bearer challenge, so MCP clients can discover
+// the authorization server through the protected resource metadata and react to the error.
+// Empty 'bearerError' is used when the request has no credentials at all, or no error code applies.
+// Ref: https://datatracker.ietf.org/doc/html/rfc9728#section-5.1
+func (mw *JWTValidationMiddleware) denyRequest(rw http.ResponseWriter, req *http.Request, status int, bearerError string, description string) {
+	mw.denyRequestWithScopes(rw, req, status, bearerError, description, nil)
+}
+
+// denyRequestWithScopes rejects a request with a Bearer challenge that also carries the scopes needed,
+// so clients can ask the authorization server for them (step-up authorization)
+func (mw *JWTValidationMiddleware) denyRequestWithScopes(rw http.ResponseWriter, req *http.Request, status int,
+	bearerError string, description string, scopes []string) {
+
+	challengeParams := []string{}
+	if resourceMetadataUrl := mw.getResourceMetadataUrl(req); resourceMetadataUrl != "" {
+		challengeParams = append(challengeParams, fmt.Sprintf("resource_metadata=%q", resourceMetadataUrl))
+	}
+	if bearerError != "" {
+		challengeParams = append(challengeParams, fmt.Sprintf("error=%q", bearerError))
+		challengeParams = append(challengeParams, fmt.Sprintf("error_description=%q", description))
+	}
+	if len(scopes) > 0 {
+		challengeParams = append(challengeParams, fmt.Sprintf("scope=%q", strings.Join(scopes, " ")))
+	}
+
+	challenge := "Bearer"
+	if len(challengeParams) > 0 {
+		challenge += " " + strings.Join(challengeParams, ", ")
+	}
+
+	rw.Header().Set("WWW-Authenticate", challenge)
+	http.Error(rw, fmt.Sprintf("RBAC: Access Denied: %s", description), status)
+}
+
+// denyDPoPRequest rejects a request with a DPoP challenge, telling the client which algorithms are accepted
+// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-7.1
+func (mw *JWTValidationMiddleware) denyDPoPRequest(rw http.ResponseWriter, description string) {
+	rw.Header().Set("WWW-Authenticate", fmt.Sprintf("DPoP error=%q, error_description=%q, algs=%q",
+		DPoPErrorInvalidProof, description, strings.Join(mw.getDPoPAlgorithms(), " ")))
+	http.Error(rw, fmt.Sprintf("RBAC: Access Denied: %s", description), http.StatusUnauthorized)
+}
+
+// getResourceMetadataUrl returns the URL of the '/.well-known/oauth-protected-resource' endpoint, when it is enabled
+func (mw *JWTValidationMiddleware) getResourceMetadataUrl(req *http.Request) string {
+	if !mw.dependencies.AppCtx.Config.OAuthProtectedResource.Enabled {
+		return ""
+	}
+
+	return GetPublicOrigin(mw.dependencies.AppCtx.Config, req) + "/.well-known/oauth-protected-resource"
+}
+
+// GetPublicOrigin returns the origin the clients use to reach the proxy.
+// It is taken from the configured resource, or from the request when it is not an absolute URL.
+// Forwarded headers are only used when trusted by config, as clients can set them
+func GetPublicOrigin(config *api.Configuration, req *http.Request) string {
+	resourceUrl, err := url.Parse(config.OAuthProtectedResource.Resource)
+	if err == nil && resourceUrl.Scheme != "" && resourceUrl.Host != "" {
+		return resourceUrl.Scheme + "://" + resourceUrl.Host
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+
+	if config.Server.Transport.HTTP.TrustForwardedHeaders {
+		// Proxies appending to the headers put the value of the first hop first
+		forwardedProto, _, _ := strings.Cut(req.Header.Get("X-Forwarded-Proto"), ",")
+		if forwardedProto = strings.TrimSpace(forwardedProto); forwardedProto == "http" || forwardedProto == "https" {
+			scheme = forwardedProto
+		}
+
+		forwardedHost, _, _ := strings.Cut(req.Header.Get("X-Forwarded-Host"), ",")
+		if forwardedHost = strings.TrimSpace(forwardedHost); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+
+	return scheme + "://" + host
+}
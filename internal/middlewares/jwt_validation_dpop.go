@@ -0,0 +1,266 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AuthSchemeBearer = "Bearer"
+	AuthSchemeDPoP   = "DPoP"
+
+	// DPoPErrorInvalidProof is the error code of challenges for invalid DPoP proofs
+	// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-7.1
+	DPoPErrorInvalidProof = "invalid_dpop_proof"
+)
+
+// errInvalidDPoPProof marks failures caused by the DPoP proof, instead of by the access token
+var errInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+// dpopReplayCache remembers the IDs of the proofs already used, until they are too old to be accepted
+type dpopReplayCache struct {
+	mu        sync.Mutex
+	registry  map[string]time.Time
+	lastPrune time.Time
+}
+
+func newDPoPReplayCache() *dpopReplayCache {
+	return &dpopReplayCache{
+		registry: map[string]time.Time{},
+	}
+}
+
+// CheckAndStore returns false when the proof ID was already used. Otherwise, it is remembered until expiration
+func (c *dpopReplayCache) CheckAndStore(key string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > time.Minute {
+		for entryKey, entryExpiresAt := range c.registry {
+			if now.After(entryExpiresAt) {
+				delete(c.registry, entryKey)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if entryExpiresAt, found := c.registry[key]; found && now.Before(entryExpiresAt) {
+		return false
+	}
+
+	c.registry[key] = expiresAt
+	return true
+}
+
+// isDPoPEnabled returns whether DPoP proofs are validated
+func (mw *JWTValidationMiddleware) isDPoPEnabled() bool {
+	return mw.dependencies.AppCtx.Config.Middleware.JWT.DPoP.Enabled || mw.isDPoPRequired()
+}
+
+// isDPoPRequired returns whether every token must be bound to a DPoP key
+func (mw *JWTValidationMiddleware) isDPoPRequired() bool {
+	return mw.dependencies.AppCtx.Config.Middleware.JWT.DPoP.Required ||
+		mw.dependencies.AppCtx.Config.OAuthProtectedResource.DPoPBoundAccessTokensRequired
+}
+
+// getDPoPAlgorithms returns the algorithms allowed to sign DPoP proofs
+func (mw *JWTValidationMiddleware) getDPoPAlgorithms() []string {
+	if algorithms := mw.dependencies.AppCtx.Config.Middleware.JWT.DPoP.Algorithms; len(algorithms) > 0 {
+		return algorithms
+	}
+	if algorithms := mw.dependencies.AppCtx.Config.OAuthProtectedResource.DPoPSigningAlgValuesSupported; len(algorithms) > 0 {
+		return algorithms
+	}
+	return supportedAlgorithms
+}
+
+// checkDPoP verifies that the request proves possession of the key the access token is bound to.
+// Errors wrapping errInvalidDPoPProof are caused by the proof, the rest by the token
+// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-7
+func (mw *JWTValidationMiddleware) checkDPoP(req *http.Request, authScheme string, accessToken string, tokenPayload map[string]any) error {
+	if !mw.isDPoPEnabled() {
+		return nil
+	}
+
+	var boundJkt string
+	if cnf, ok := tokenPayload["cnf"].(map[string]any); ok {
+		boundJkt, _ = cnf["jkt"].(string)
+	}
+
+	if boundJkt == "" {
+		if mw.isDPoPRequired() || authScheme == AuthSchemeDPoP {
+			return fmt.Errorf("token is not bound to a DPoP key")
+		}
+		return nil
+	}
+
+	// Bound tokens are useless without the key, so they are never accepted as Bearer tokens
+	if authScheme != AuthSchemeDPoP {
+		return fmt.Errorf("DPoP-bound token sent with '%s' scheme", authScheme)
+	}
+
+	proofJkt, err := mw.validateDPoPProof(req, accessToken)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidDPoPProof, err.Error())
+	}
+
+	if proofJkt != boundJkt {
+		return fmt.Errorf("%w: proof key does not match the token binding", errInvalidDPoPProof)
+	}
+
+	return nil
+}
+
+// validateDPoPProof validates the DPoP proof of a request, and returns the thumbprint of the key that signed it
+func (mw *JWTValidationMiddleware) validateDPoPProof(req *http.Request, accessToken string) (string, error) {
+	proofHeaders := req.Header.Values(AuthSchemeDPoP)
+	if len(proofHeaders) != 1 {
+		return "", fmt.Errorf("exactly one DPoP header is required")
+	}
+
+	var proofJwk *JWK
+	parsedProof, err := jwt.Parse(proofHeaders[0], func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("unexpected proof type")
+		}
+
+		jwkHeader, ok := token.Header["jwk"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("proof header 'jwk' field not found")
+		}
+
+		// Proofs must carry public keys only
+		if _, found := jwkHeader["d"]; found {
+			return nil, fmt.Errorf("proof key is private")
+		}
+
+		jwkBytes, localErr := json.Marshal(jwkHeader)
+		if localErr != nil {
+			return nil, localErr
+		}
+
+		proofJwk = &JWK{}
+		if localErr = json.Unmarshal(jwkBytes, proofJwk); localErr != nil {
+			return nil, localErr
+		}
+
+		return jwkToKey(proofJwk)
+	}, jwt.WithValidMethods(mw.getDPoPAlgorithms()), jwt.WithoutClaimsValidation())
+
+	if err != nil || !parsedProof.Valid {
+		return "", fmt.Errorf("invalid proof: %v", err)
+	}
+
+	claims, ok := parsedProof.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", fmt.Errorf("invalid proof claims type")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", fmt.Errorf("proof claim 'jti' not found")
+	}
+
+	if htm, _ := claims["htm"].(string); htm != req.Method {
+		return "", fmt.Errorf("proof method mismatch")
+	}
+
+	htu, _ := claims["htu"].(string)
+	if !mw.isDPoPTargetUri(req, htu) {
+		return "", fmt.Errorf("proof URI mismatch")
+	}
+
+	// Proofs are only valid for a short time after being issued
+	dpopConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.DPoP
+	leeway := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.Leeway
+
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return "", fmt.Errorf("proof claim 'iat' not found")
+	}
+	issuedAt := time.Unix(int64(math.Floor(iat)), 0)
+	if time.Since(issuedAt) > dpopConfig.ProofMaxAge+leeway || time.Until(issuedAt) > leeway {
+		return "", fmt.Errorf("proof is expired or issued in the future")
+	}
+
+	// Proofs are bound to the access token too
+	accessTokenHash := sha256.Sum256([]byte(accessToken))
+	if ath, _ := claims["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(accessTokenHash[:]) {
+		return "", fmt.Errorf("proof access token hash mismatch")
+	}
+
+	jkt, err := getJWKThumbprint(proofJwk)
+	if err != nil {
+		return "", err
+	}
+
+	if !mw.dpopReplayCache.CheckAndStore(jkt+"/"+jti, issuedAt.Add(dpopConfig.ProofMaxAge+2*leeway)) {
+		return "", fmt.Errorf("proof was already used")
+	}
+
+	return jkt, nil
+}
+
+// isDPoPTargetUri checks whether the 'htu' claim of a proof points to the requested URI.
+// Query and fragment are ignored, as stated in the RFC
+func (mw *JWTValidationMiddleware) isDPoPTargetUri(req *http.Request, htu string) bool {
+	htuUrl, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+
+	requestUrl, err := url.Parse(GetPublicOrigin(mw.dependencies.AppCtx.Config, req) + req.URL.Path)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(htuUrl.Scheme, requestUrl.Scheme) &&
+		strings.EqualFold(htuUrl.Host, requestUrl.Host) &&
+		htuUrl.Path == requestUrl.Path
+}
+
+// getJWKThumbprint returns the SHA-256 thumbprint of a public JWK
+// Ref: https://datatracker.ietf.org/doc/html/rfc7638
+func getJWKThumbprint(jwk *JWK) (string, error) {
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+
+	// Members are serialized in lexicographic order, without whitespaces
+	keys := make([]string, 0, len(members))
+	for key := range members {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		valueBytes, _ := json.Marshal(members[key])
+		parts = append(parts, fmt.Sprintf("%q:%s", key, valueBytes))
+	}
+
+	thumbprint := sha256.Sum256([]byte("{" + strings.Join(parts, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
@@ -0,0 +1,143 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-proxy/api"
+)
+
+// introspectionCacheEntry represents the cached result of an active token introspection
+type introspectionCacheEntry struct {
+	Claims    map[string]any
+	ExpiresAt time.Time
+}
+
+// introspectionCache keeps active introspection results by token hash,
+// so the authorization server is not asked on every request
+type introspectionCache struct {
+	mu        sync.Mutex
+	registry  map[string]introspectionCacheEntry
+	lastPrune time.Time
+}
+
+func newIntrospectionCache() *introspectionCache {
+	return &introspectionCache{
+		registry: map[string]introspectionCacheEntry{},
+	}
+}
+
+// Get returns the claims of a token when they are cached and not expired
+func (c *introspectionCache) Get(key string) (map[string]any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.registry[key]
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return nil, false
+	}
+	return entry.Claims, true
+}
+
+// Set stores the claims of a token, pruning expired entries from time to time
+func (c *introspectionCache) Set(key string, claims map[string]any, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > time.Minute {
+		for entryKey, entry := range c.registry {
+			if now.After(entry.ExpiresAt) {
+				delete(c.registry, entryKey)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	c.registry[key] = introspectionCacheEntry{Claims: claims, ExpiresAt: expiresAt}
+}
+
+// introspectToken returns the claims of an active token, asking the introspection endpoint when they are not cached.
+// Inactive tokens are never cached, so revocations are noticed as soon as possible
+func (mw *JWTValidationMiddleware) introspectToken(tokenString string) (map[string]any, error) {
+	tokenHash := sha256.Sum256([]byte(tokenString))
+	cacheKey := hex.EncodeToString(tokenHash[:])
+
+	if claims, ok := mw.introspectionCache.Get(cacheKey); ok {
+		return claims, nil
+	}
+
+	introspectionConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Introspection
+
+	form := url.Values{}
+	form.Set("token", tokenString)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequest(http.MethodPost, introspectionConfig.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed creating introspection request: %s", errTokenValidationUnavailable, err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(introspectionConfig.ClientID), url.QueryEscape(introspectionConfig.ClientSecret))
+
+	resp, err := mw.introspectionClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed calling introspection endpoint: %s", errTokenValidationUnavailable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: unexpected introspection status code: %d", errTokenValidationUnavailable, resp.StatusCode)
+	}
+
+	claims := map[string]any{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%w: failed decoding introspection response: %s", errTokenValidationUnavailable, err.Error())
+	}
+
+	if active, _ := claims["active"].(bool); !active {
+		return nil, fmt.Errorf("token is not active")
+	}
+
+	// Active tokens issued for other resources are not valid here
+	if !isAnyAudienceAllowed(claims["aud"], getIntrospectionAudiences(mw.dependencies.AppCtx.Config)) {
+		return nil, fmt.Errorf("audience mismatch")
+	}
+
+	// Tokens without expiration are cached for a short time, at most
+	expiresAt := time.Now().Add(time.Minute)
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt = time.Unix(int64(exp), 0)
+		if time.Now().After(expiresAt) {
+			return nil, fmt.Errorf("token is expired")
+		}
+	}
+
+	if introspectionConfig.CacheMaxTTL > 0 && time.Until(expiresAt) > introspectionConfig.CacheMaxTTL {
+		expiresAt = time.Now().Add(introspectionConfig.CacheMaxTTL)
+	}
+
+	mw.introspectionCache.Set(cacheKey, claims, expiresAt)
+	return claims, nil
+}
+
+// getIntrospectionAudiences returns the audiences accepted in introspected tokens
+func getIntrospectionAudiences(config *api.Configuration) []string {
+	if audiences := config.Middleware.JWT.Validation.Introspection.Audiences; len(audiences) > 0 {
+		return audiences
+	}
+
+	if config.OAuthProtectedResource.Resource != "" {
+		return []string{config.OAuthProtectedResource.Resource}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package middlewares
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	//
+	"mcp-proxy/api"
+
+	//
+	"github.com/google/cel-go/cel"
+)
+
+// trustedIssuer represents an issuer whose tokens are accepted by the local JWT validation
+type trustedIssuer struct {
+	config       api.JWTValidationIssuerConfig
+	jwksProvider *jwksProvider
+	celPrograms  []*cel.Program
+}
+
+// getTrustedIssuersConfig returns the issuers trusted by the local JWT validation.
+// Without explicit issuers, the authorization server issuer is trusted for the protected resource, when keys are set
+func getTrustedIssuersConfig(config *api.Configuration) []api.JWTValidationIssuerConfig {
+	if len(config.Middleware.JWT.Validation.Local.Issuers) > 0 {
+		return config.Middleware.JWT.Validation.Local.Issuers
+	}
+
+	// Keys are needed to trust the authorization server. They are missing when only the built-in one is used
+	localConfig := config.Middleware.JWT.Validation.Local
+	if localConfig.JWKSUri == "" && localConfig.JWKSFile == "" && localConfig.JWKS == "" {
+		return nil
+	}
+
+	issuerConfig := api.JWTValidationIssuerConfig{
+		Issuer:                  config.OAuthAuthorizationServer.IssuerUri,
+		JWTValidationJWKSConfig: config.Middleware.JWT.Validation.Local.JWTValidationJWKSConfig,
+	}
+	if config.OAuthProtectedResource.Resource != "" {
+		issuerConfig.Audiences = []string{config.OAuthProtectedResource.Resource}
+	}
+
+	return []api.JWTValidationIssuerConfig{issuerConfig}
+}
+
+// getDiscoveryUri returns the URI of the OIDC metadata used to discover the JWKS URI of an issuer.
+// It is empty when discovery is disabled or the keys have another source
+func getDiscoveryUri(issuerConfig api.JWTValidationIssuerConfig) string {
+	if !issuerConfig.Discovery || issuerConfig.JWKSUri != "" || issuerConfig.JWKSFile != "" || issuerConfig.JWKS != "" {
+		return ""
+	}
+
+	return normalizeIssuer(issuerConfig.Issuer) + "/.well-known/openid-configuration"
+}
+
+// getTrustedIssuer returns the trusted issuer matching the 'iss' claim of a token, if any
+func (mw *JWTValidationMiddleware) getTrustedIssuer(iss string) *trustedIssuer {
+	if iss == "" {
+		return nil
+	}
+
+	for _, issuer := range mw.trustedIssuers {
+		if issuer.config.Issuer != "" && normalizeIssuer(issuer.config.Issuer) == normalizeIssuer(iss) {
+			return issuer
+		}
+	}
+	return nil
+}
+
+// isAudienceAllowed checks whether any of the audiences of a token is allowed by the issuer
+func (issuer *trustedIssuer) isAudienceAllowed(audClaim any) bool {
+	return isAnyAudienceAllowed(audClaim, issuer.config.Audiences)
+}
+
+// isAnyAudienceAllowed checks whether any of the audiences of an 'aud' claim, a string or a list of them, is allowed
+func isAnyAudienceAllowed(audClaim any, allowedAudiences []string) bool {
+	switch aud := audClaim.(type) {
+	case string:
+		return slices.Contains(allowedAudiences, aud)
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && slices.Contains(allowedAudiences, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAlgorithmAllowed checks whether the issuer signs tokens with an algorithm.
+// All the supported algorithms are allowed when the issuer does not restrict them
+func (issuer *trustedIssuer) isAlgorithmAllowed(alg string) bool {
+	return len(issuer.config.Algorithms) == 0 || slices.Contains(issuer.config.Algorithms, alg)
+}
+
+// supportedAlgorithms are the algorithms allowed to sign tokens when they are not configured.
+// HMAC ones are not supported, as the keys are published
+var supportedAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// isAlgorithmAllowed checks whether tokens signed with an algorithm are accepted at all
+func (mw *JWTValidationMiddleware) isAlgorithmAllowed(alg string) bool {
+	allowedAlgorithms := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.Algorithms
+	if len(allowedAlgorithms) == 0 {
+		allowedAlgorithms = supportedAlgorithms
+	}
+
+	return slices.Contains(allowedAlgorithms, alg) && slices.Contains(supportedAlgorithms, alg)
+}
+
+// normalizeIssuer removes possible trailing slashes for comparison
+func normalizeIssuer(issuer string) string {
+	return strings.TrimRight(issuer, "/")
+}
+
+// CheckJWKS checks the keys of every trusted issuer were loaded, so their tokens can be validated
+func (mw *JWTValidationMiddleware) CheckJWKS() error {
+	var notLoaded []string
+	for _, issuer := range mw.trustedIssuers {
+		if !issuer.jwksProvider.IsLoaded() {
+			notLoaded = append(notLoaded, issuer.config.Issuer)
+		}
+	}
+
+	if len(notLoaded) > 0 {
+		return fmt.Errorf("JWKS not loaded for issuers: %s", strings.Join(notLoaded, ", "))
+	}
+	return nil
+}
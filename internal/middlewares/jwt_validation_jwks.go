@@ -0,0 +1,351 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-proxy/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksProvider keeps the keys used to validate JWTs up-to-date.
+// Last known good keys are kept when a refresh fails, so a flaky source does not reject valid tokens
+type jwksProvider struct {
+	config       api.JWTValidationJWKSConfig
+	discoveryUri string
+	logger       *slog.Logger
+	client       *http.Client
+
+	mutex sync.RWMutex
+	jwks  *JWKS
+
+	// Fields only used while refreshing, protected by refreshMutex
+	refreshMutex sync.Mutex
+	lastRefresh  time.Time
+	jwksUri      string
+	etag         string
+	lastModified string
+	fileModTime  time.Time
+}
+
+// newJWKSProvider creates a provider for the keys of a JWKS source.
+// When 'discoveryUri' is set and the JWKS URI is not, the URI is obtained from that OIDC metadata document
+func newJWKSProvider(config api.JWTValidationJWKSConfig, discoveryUri string, logger *slog.Logger) *jwksProvider {
+	return &jwksProvider{
+		config:       config,
+		discoveryUri: discoveryUri,
+		logger:       logger,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		jwksUri:      config.JWKSUri,
+	}
+}
+
+// Run loads the keys synchronously, so they are ready before serving,
+// and keeps them up-to-date in background until the context is done
+func (p *jwksProvider) Run(ctx context.Context) {
+	nextRefresh := p.refresh(true)
+
+	// Inline keys never change
+	if p.config.JWKSUri == "" && p.discoveryUri == "" && p.config.JWKSFile == "" {
+		return
+	}
+
+	go func() {
+		p.logger.Info("JWKS cache daemon running for JWT auth middleware")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(nextRefresh):
+				nextRefresh = p.refresh(true)
+			}
+		}
+	}()
+}
+
+// IsLoaded returns whether keys were loaded at least once
+func (p *jwksProvider) IsLoaded() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.jwks != nil
+}
+
+// GetKey returns the signing key identified by 'kid'.
+// Keys are refreshed when it is unknown, as the issuer may have rotated them
+func (p *jwksProvider) GetKey(kid string) (*JWK, error) {
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	p.refresh(false)
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	if !p.IsLoaded() {
+		return nil, fmt.Errorf("JWKS not loaded yet")
+	}
+	return nil, fmt.Errorf("no matching 'kid' in JWKS")
+}
+
+// lookupKey looks for the published key with the same 'kid' in the current keys
+func (p *jwksProvider) lookupKey(kid string) *JWK {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.jwks == nil {
+		return nil
+	}
+
+	for _, key := range p.jwks.Keys {
+		if key.Kid == kid && (key.Use == "" || key.Use == "sig") {
+			return &key
+		}
+	}
+	return nil
+}
+
+// refresh loads the keys from their source, and returns the time to wait before the next refresh.
+// Non-forced refreshes are rate limited, as they are triggered by incoming tokens
+func (p *jwksProvider) refresh(force bool) time.Duration {
+	p.refreshMutex.Lock()
+	defer p.refreshMutex.Unlock()
+
+	if !force && time.Since(p.lastRefresh) < p.config.MinRefreshInterval {
+		return p.config.CacheInterval
+	}
+	p.lastRefresh = time.Now()
+
+	var jwks *JWKS
+	var err error
+	nextRefresh := p.config.CacheInterval
+
+	switch {
+	case p.config.JWKSUri != "" || p.discoveryUri != "":
+		jwks, nextRefresh, err = p.fetchRemote()
+	case p.config.JWKSFile != "":
+		jwks, err = p.readFile()
+	default:
+		jwks, err = parseJWKS([]byte(p.config.JWKS))
+	}
+
+	if err != nil {
+		p.logger.Error("failed refreshing JWKS, keeping last known good keys", "error", err.Error())
+		return p.config.MinRefreshInterval
+	}
+
+	// Nil keys mean they did not change since the last refresh
+	if jwks != nil {
+		p.mutex.Lock()
+		p.jwks = jwks
+		p.mutex.Unlock()
+	}
+
+	return max(nextRefresh, p.config.MinRefreshInterval)
+}
+
+// fetchRemote obtains the keys from the JWKS URI, honoring HTTP cache headers.
+// Validators from the last response are sent, so unchanged keys are not transferred again
+func (p *jwksProvider) fetchRemote() (*JWKS, time.Duration, error) {
+	if p.jwksUri == "" {
+		jwksUri, err := p.discoverJWKSUri()
+		if err != nil {
+			return nil, 0, err
+		}
+		p.jwksUri = jwksUri
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.jwksUri, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed creating JWKS request: %s", err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if p.IsLoaded() {
+		if p.etag != "" {
+			req.Header.Set("If-None-Match", p.etag)
+		}
+		if p.lastModified != "" {
+			req.Header.Set("If-Modified-Since", p.lastModified)
+		}
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed getting JWKS from remote: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	cacheTTL, cacheable := getCacheTTL(resp.Header)
+	if !cacheable {
+		cacheTTL = p.config.CacheInterval
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, cacheTTL, nil
+	case http.StatusOK:
+	default:
+		return nil, 0, fmt.Errorf("unexpected JWKS status code: %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, 0, fmt.Errorf("failed decoding JWKS from remote: %s", err.Error())
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, 0, fmt.Errorf("JWKS from remote has no keys")
+	}
+
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+
+	return &jwks, cacheTTL, nil
+}
+
+// discoverJWKSUri obtains the JWKS URI from the OIDC metadata document
+func (p *jwksProvider) discoverJWKSUri() (string, error) {
+	resp, err := p.client.Get(p.discoveryUri)
+	if err != nil {
+		return "", fmt.Errorf("failed getting OIDC metadata: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected OIDC metadata status code: %d", resp.StatusCode)
+	}
+
+	metadata := struct {
+		JWKSUri string `json:"jwks_uri"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("failed decoding OIDC metadata: %s", err.Error())
+	}
+	if metadata.JWKSUri == "" {
+		return "", fmt.Errorf("OIDC metadata has no 'jwks_uri'")
+	}
+
+	return metadata.JWKSUri, nil
+}
+
+// readFile obtains the keys from the JWKS file, only when it changed since the last read
+func (p *jwksProvider) readFile() (*JWKS, error) {
+	fileInfo, err := os.Stat(p.config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading JWKS file: %s", err.Error())
+	}
+
+	if p.IsLoaded() && fileInfo.ModTime().Equal(p.fileModTime) {
+		return nil, nil
+	}
+
+	fileBytes, err := os.ReadFile(p.config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading JWKS file: %s", err.Error())
+	}
+
+	jwks, err := parseJWKS(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	p.fileModTime = fileInfo.ModTime()
+	return jwks, nil
+}
+
+// parseJWKS decodes a JWKS from its JSON representation
+func parseJWKS(jwksBytes []byte) (*JWKS, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(jwksBytes, &jwks); err != nil {
+		return nil, fmt.Errorf("failed decoding JWKS: %s", err.Error())
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no keys")
+	}
+	return &jwks, nil
+}
+
+// getCacheTTL returns how long a response can be cached according to its headers.
+// 'Cache-Control: max-age' takes precedence over 'Expires', as stated in RFC 9111
+func getCacheTTL(header http.Header) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		if directive == "no-store" || directive == "no-cache" {
+			return 0, false
+		}
+
+		if maxAge, found := strings.CutPrefix(directive, "max-age="); found {
+			seconds, err := strconv.Atoi(maxAge)
+			if err == nil {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err == nil {
+			return time.Until(expiresAt), true
+		}
+	}
+
+	return 0, false
+}
+
+// KeySet provides the keys published by an issuer, to verify its tokens outside the middleware,
+// such as the ID tokens received by the built-in authorization server
+type KeySet struct {
+	provider *jwksProvider
+}
+
+// NewKeySet creates a key set for the JWKS published at 'jwksUri'.
+// Keys are fetched on demand, and refreshed at most once per 'minRefreshInterval'
+func NewKeySet(jwksUri string, minRefreshInterval time.Duration, logger *slog.Logger) *KeySet {
+	return &KeySet{
+		provider: newJWKSProvider(api.JWTValidationJWKSConfig{
+			JWKSUri:            jwksUri,
+			CacheInterval:      api.DefaultJWKSCacheInterval,
+			MinRefreshInterval: minRefreshInterval,
+		}, "", logger),
+	}
+}
+
+// Keyfunc returns the public key matching the header of a token, to be used with jwt.Parse.
+// Only asymmetric algorithms are accepted, as the keys are published
+func (s *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("jwt header 'kid' field not found")
+	}
+
+	alg, _ := token.Header["alg"].(string)
+	if !slices.Contains(supportedAlgorithms, alg) {
+		return nil, fmt.Errorf("algorithm not allowed")
+	}
+
+	matchingKey, err := s.provider.GetKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if matchingKey.Alg != "" && matchingKey.Alg != alg {
+		return nil, fmt.Errorf("algorithm missmatch")
+	}
+
+	return jwkToKey(matchingKey)
+}
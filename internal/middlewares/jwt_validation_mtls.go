@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// checkCertificateBinding verifies that certificate-bound tokens are presented over a connection
+// authenticated with the same client certificate. Bound tokens are rejected when there is no client certificate,
+// such as when mutual TLS is not enabled, as the binding could not be proven then
+// Ref: https://datatracker.ietf.org/doc/html/rfc8705#section-3
+func (mw *JWTValidationMiddleware) checkCertificateBinding(req *http.Request, tokenPayload map[string]any) error {
+	var boundThumbprint string
+	if cnf, ok := tokenPayload["cnf"].(map[string]any); ok {
+		boundThumbprint, _ = cnf["x5t#S256"].(string)
+	}
+
+	if boundThumbprint == "" {
+		return nil
+	}
+
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("certificate-bound token sent without client certificate")
+	}
+
+	certificateThumbprint := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	if base64.RawURLEncoding.EncodeToString(certificateThumbprint[:]) != boundThumbprint {
+		return fmt.Errorf("client certificate does not match the token binding")
+	}
+
+	return nil
+}
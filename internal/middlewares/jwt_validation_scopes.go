@@ -0,0 +1,247 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// callToolToolName is the name of the proxy tool that executes backend tools.
+	// Scopes are required for the backend tool it executes, instead of for itself
+	callToolToolName = "call_tool"
+
+	// maxScopesBodyBytes limits the size of the bodies read to decide the required scopes
+	maxScopesBodyBytes = 4 * 1024 * 1024
+)
+
+// jsonRPCRequest represents the fields of a JSON-RPC request needed to decide the required scopes
+type jsonRPCRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Name      string         `json:"name"`
+		URI       string         `json:"uri"`
+		Arguments map[string]any `json:"arguments"`
+
+		// Prompt or resource whose arguments are completed
+		Ref struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+			URI  string `json:"uri"`
+		} `json:"ref"`
+	} `json:"params"`
+}
+
+// getRequiredScopes returns the scopes needed to perform the MCP requests carried in the body of an HTTP request.
+// The body is restored, so next stages can read it again. Bodies too large or not being JSON-RPC are errors,
+// as the scopes they need can not be decided
+func (mw *JWTValidationMiddleware) getRequiredScopes(rw http.ResponseWriter, req *http.Request) ([]string, error) {
+	scopesConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.Scopes
+	if req.Method != http.MethodPost || (len(scopesConfig.Methods) == 0 && len(scopesConfig.Tools) == 0 &&
+		len(scopesConfig.Prompts) == 0 && len(scopesConfig.Resources) == 0) {
+		return nil, nil
+	}
+
+	bodyBytes, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, maxScopesBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+	// Messages can be sent in batches
+	var messages []jsonRPCRequest
+	if trimmedBody := bytes.TrimSpace(bodyBytes); len(trimmedBody) > 0 && trimmedBody[0] == '[' {
+		err = json.Unmarshal(trimmedBody, &messages)
+	} else {
+		var message jsonRPCRequest
+		err = json.Unmarshal(trimmedBody, &message)
+		messages = append(messages, message)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JSON-RPC messages: %s", err.Error())
+	}
+
+	requiredScopes := []string{}
+	for _, message := range messages {
+		for _, rule := range scopesConfig.Methods {
+			if matched, _ := path.Match(rule.Method, message.Method); matched {
+				requiredScopes = appendMissingScopes(requiredScopes, rule.Scopes)
+			}
+		}
+
+		if message.Method == string(mcp.MethodToolsCall) && len(scopesConfig.Tools) > 0 {
+			requiredScopes = appendMissingScopes(requiredScopes, mw.getToolRequiredScopes(req.Context(), message))
+		}
+
+		requiredScopes = appendMissingScopes(requiredScopes, mw.getPromptOrResourceRequiredScopes(message))
+	}
+
+	return requiredScopes, nil
+}
+
+// getPromptOrResourceRequiredScopes returns the scopes needed to use a prompt or a resource.
+// Completions of their arguments require the same scopes, as they disclose their content
+func (mw *JWTValidationMiddleware) getPromptOrResourceRequiredScopes(message jsonRPCRequest) []string {
+	var promptName, resourceUri string
+	switch message.Method {
+	case string(mcp.MethodPromptsGet):
+		promptName = message.Params.Name
+	case string(mcp.MethodResourcesRead):
+		resourceUri = message.Params.URI
+	case string(mcp.MethodCompletionComplete):
+		switch message.Params.Ref.Type {
+		case "ref/prompt":
+			promptName = message.Params.Ref.Name
+		case "ref/resource":
+			resourceUri = message.Params.Ref.URI
+		}
+	default:
+		return nil
+	}
+
+	scopesConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.Scopes
+	requiredScopes := []string{}
+	if promptName != "" {
+		for _, rule := range scopesConfig.Prompts {
+			if matched, _ := path.Match(rule.Prompt, promptName); matched {
+				requiredScopes = appendMissingScopes(requiredScopes, rule.Scopes)
+			}
+		}
+	}
+
+	if resourceUri != "" {
+		for _, rule := range scopesConfig.Resources {
+			if matched, _ := path.Match(rule.Resource, resourceUri); matched {
+				requiredScopes = appendMissingScopes(requiredScopes, rule.Scopes)
+			}
+		}
+	}
+
+	return requiredScopes
+}
+
+// getToolRequiredScopes returns the scopes needed to call a tool, according to its name and annotations
+func (mw *JWTValidationMiddleware) getToolRequiredScopes(ctx context.Context, message jsonRPCRequest) []string {
+	toolName := message.Params.Name
+	isBackendTool := false
+	if toolName == callToolToolName {
+		backendToolName, _ := message.Params.Arguments["name"].(string)
+		toolName = backendToolName[strings.LastIndex(backendToolName, ":")+1:]
+		isBackendTool = true
+	}
+
+	// Tool definitions are only fetched when annotations are involved, as it may require asking the backend
+	var tool *mcp.Tool
+
+	requiredScopes := []string{}
+	for _, rule := range mw.dependencies.AppCtx.Config.Middleware.JWT.Scopes.Tools {
+		if rule.Tool != "" {
+			if matched, _ := path.Match(rule.Tool, toolName); matched {
+				requiredScopes = appendMissingScopes(requiredScopes, rule.Scopes)
+			}
+			continue
+		}
+
+		if rule.Annotation == "" || !isBackendTool {
+			continue
+		}
+
+		if tool == nil {
+			tool = &mcp.Tool{}
+			if mw.dependencies.GetBackendTool != nil {
+				backendTool, found, err := mw.dependencies.GetBackendTool(ctx, toolName)
+				if err != nil {
+					mw.dependencies.AppCtx.Logger.Warn("failed getting backend tool definition", "tool", toolName, "error", err.Error())
+				}
+				if err == nil && found {
+					*tool = backendTool
+				}
+			}
+		}
+
+		// Unknown tools require the scopes of every annotation, to fail safely
+		if tool.Name == "" || hasToolAnnotation(*tool, rule.Annotation) {
+			requiredScopes = appendMissingScopes(requiredScopes, rule.Scopes)
+		}
+	}
+
+	return requiredScopes
+}
+
+// hasToolAnnotation checks whether a tool is annotated with a hint.
+// Unset hints take the default values defined in the MCP specification
+func hasToolAnnotation(tool mcp.Tool, annotation string) bool {
+	isTrue := func(hint *bool, defaultValue bool) bool {
+		if hint == nil {
+			return defaultValue
+		}
+		return *hint
+	}
+
+	readOnly := isTrue(tool.Annotations.ReadOnlyHint, false)
+
+	switch annotation {
+	case "read_only":
+		return readOnly
+	case "destructive":
+		return !readOnly && isTrue(tool.Annotations.DestructiveHint, true)
+	case "idempotent":
+		return isTrue(tool.Annotations.IdempotentHint, false)
+	case "open_world":
+		return isTrue(tool.Annotations.OpenWorldHint, true)
+	default:
+		return false
+	}
+}
+
+// getTokenScopes returns the scopes granted to a token.
+// They are found in 'scope' claim as a space-separated string (RFC 8693), or in 'scp' claim as a string or a list
+func getTokenScopes(tokenPayload map[string]any) []string {
+	scopes := []string{}
+
+	for _, claim := range []string{"scope", "scp"} {
+		switch value := tokenPayload[claim].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(value)...)
+		case []any:
+			for _, item := range value {
+				if scope, ok := item.(string); ok {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+
+	return scopes
+}
+
+// getMissingScopes returns the required scopes not granted to a token
+func getMissingScopes(requiredScopes []string, grantedScopes []string) []string {
+	missingScopes := []string{}
+	for _, scope := range requiredScopes {
+		if !slices.Contains(grantedScopes, scope) {
+			missingScopes = append(missingScopes, scope)
+		}
+	}
+	return missingScopes
+}
+
+// appendMissingScopes appends the scopes not already present in the list
+func appendMissingScopes(scopes []string, newScopes []string) []string {
+	for _, scope := range newScopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,325 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"mcp-proxy/api"
+	"mcp-proxy/internal/globals"
+)
+
+const testForwardedHeader = "X-Validated-Jwt"
+
+func encodeTestPayload(payload string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(payload))
+}
+
+func TestParseForwardedPayload(t *testing.T) {
+	tests := []struct {
+		name        string
+		headerValue string
+		wantSubject string
+		wantErr     bool
+	}{
+		{"base64 payload", encodeTestPayload(`{"sub":"user"}`), "user", false},
+		{"padded standard base64 payload", base64.StdEncoding.EncodeToString([]byte(`{"sub":"user?"}`)), "user?", false},
+		{"whole JWT", "header." + encodeTestPayload(`{"sub":"user"}`) + ".signature", "user", false},
+		{"bearer JWT", "Bearer header." + encodeTestPayload(`{"sub":"user"}`) + ".signature", "user", false},
+
+		{"null payload", encodeTestPayload(`null`), "", true},
+		{"null payload in JWT", "header." + encodeTestPayload(`null`) + ".signature", "", true},
+		{"empty object payload", encodeTestPayload(`{}`), "", true},
+		{"array payload", encodeTestPayload(`["sub"]`), "", true},
+		{"not base64", "not base64!", "", true},
+		{"not JSON", encodeTestPayload(`sub=user`), "", true},
+		{"two parts", "header.payload", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := parseForwardedPayload(test.headerValue)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseForwardedPayload() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				if payload != nil {
+					t.Errorf("parseForwardedPayload() = %v, want nil payload along with the error", payload)
+				}
+				return
+			}
+			if payload["sub"] != test.wantSubject {
+				t.Errorf("parseForwardedPayload() sub = %v, want %q", payload["sub"], test.wantSubject)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareForwardedPayload(t *testing.T) {
+	config := &api.Configuration{}
+	config.Middleware.JWT.Enabled = true
+	config.Middleware.JWT.Validation.Strategy = "external"
+	config.Middleware.JWT.Validation.ForwardedHeader = testForwardedHeader
+	config.Middleware.JWT.Validation.AllowConditions = []api.JWTValidationAllowCondition{
+		{Expression: `has(payload.sub) && payload.sub == "allowed"`},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mw, err := NewJWTValidationMiddleware(JWTValidationMiddlewareDependencies{
+		AppCtx: &globals.ApplicationContext{
+			Context: ctx,
+			Cancel:  cancel,
+			Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Config:  config,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed creating middleware: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		headerValue string
+		statusCode  int
+	}{
+		{"payload meeting conditions", encodeTestPayload(`{"sub":"allowed"}`), http.StatusOK},
+		{"payload not meeting conditions", encodeTestPayload(`{"sub":"other"}`), http.StatusForbidden},
+
+		// Checks must never be skipped for payloads decoded as nil or empty
+		{"null payload", encodeTestPayload(`null`), http.StatusUnauthorized},
+		{"null payload in JWT", "header." + encodeTestPayload(`null`) + ".signature", http.StatusUnauthorized},
+		{"empty object payload", encodeTestPayload(`{}`), http.StatusUnauthorized},
+		{"malformed payload", "not base64!", http.StatusUnauthorized},
+		{"missing header", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nextCalled := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				nextCalled = true
+				if _, found := JWTPayloadFromContext(req.Context()); !found {
+					t.Error("payload not found in the context of the next stage")
+				}
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+			if test.headerValue != "" {
+				request.Header.Set(testForwardedHeader, test.headerValue)
+			}
+
+			recorder := httptest.NewRecorder()
+			mw.Middleware(next).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("got status %d, want %d", recorder.Code, test.statusCode)
+			}
+
+			// Unmet conditions can not be fixed by asking for other scopes
+			if challenge := recorder.Header().Get("WWW-Authenticate"); strings.Contains(challenge, BearerErrorInsufficientScope) {
+				t.Errorf("got challenge %q, want no %q error", challenge, BearerErrorInsufficientScope)
+			}
+			if nextCalled != (test.statusCode == http.StatusOK) {
+				t.Errorf("next stage called = %v, want %v", nextCalled, test.statusCode == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestGetPublicOrigin(t *testing.T) {
+	forwardedHeaders := map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "mcp.example.com"}
+
+	tests := []struct {
+		name           string
+		resource       string
+		trustForwarded bool
+		headers        map[string]string
+		want           string
+	}{
+		{"configured resource", "https://mcp-proxy.example.com/mcp", false, nil, "https://mcp-proxy.example.com"},
+		{"configured resource over forwarded headers", "https://mcp-proxy.example.com/mcp", true, forwardedHeaders, "https://mcp-proxy.example.com"},
+		{"request host", "", false, nil, "http://proxy.internal:8080"},
+		{"untrusted forwarded headers", "", false, forwardedHeaders, "http://proxy.internal:8080"},
+		{"trusted forwarded headers", "", true, forwardedHeaders, "https://mcp.example.com"},
+		{"first hop of trusted forwarded headers", "", true, map[string]string{"X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "mcp.example.com, proxy.internal"}, "https://mcp.example.com"},
+		{"unknown forwarded scheme", "", true, map[string]string{"X-Forwarded-Proto": "javascript"}, "http://proxy.internal:8080"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &api.Configuration{}
+			config.OAuthProtectedResource.Resource = test.resource
+			config.Server.Transport.HTTP.TrustForwardedHeaders = test.trustForwarded
+
+			request := httptest.NewRequest(http.MethodGet, "http://proxy.internal:8080/mcp", nil)
+			for header, value := range test.headers {
+				request.Header.Set(header, value)
+			}
+
+			if origin := GetPublicOrigin(config, request); origin != test.want {
+				t.Errorf("GetPublicOrigin() = %q, want %q", origin, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckCertificateBinding(t *testing.T) {
+	certificate := &x509.Certificate{Raw: []byte("client certificate")}
+	thumbprint := sha256.Sum256(certificate.Raw)
+	boundPayload := map[string]any{"cnf": map[string]any{"x5t#S256": base64.RawURLEncoding.EncodeToString(thumbprint[:])}}
+
+	tests := []struct {
+		name        string
+		payload     map[string]any
+		tlsState    *tls.ConnectionState
+		wantAllowed bool
+	}{
+		{"unbound token without TLS", map[string]any{"sub": "user"}, nil, true},
+		{"bound token without TLS", boundPayload, nil, false},
+		{"bound token without client certificate", boundPayload, &tls.ConnectionState{}, false},
+		{"bound token with its certificate", boundPayload, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}, true},
+		{"bound token with another certificate", boundPayload,
+			&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("another certificate")}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "https://mcp-proxy.example.com/mcp", nil)
+			request.TLS = test.tlsState
+
+			err := (&JWTValidationMiddleware{}).checkCertificateBinding(request, test.payload)
+			if allowed := err == nil; allowed != test.wantAllowed {
+				t.Errorf("checkCertificateBinding() error = %v, want allowed %v", err, test.wantAllowed)
+			}
+		})
+	}
+}
+
+// newTestIntrospectionMiddleware returns a middleware validating tokens with the introspection endpoint given
+func newTestIntrospectionMiddleware(t *testing.T, endpoint string) (*JWTValidationMiddleware, error) {
+	t.Helper()
+
+	config := &api.Configuration{}
+	config.Middleware.JWT.Enabled = true
+	config.Middleware.JWT.Validation.Strategy = "introspection"
+	config.Middleware.JWT.Validation.ForwardedHeader = testForwardedHeader
+	config.Middleware.JWT.Validation.Introspection.Endpoint = endpoint
+	config.Middleware.JWT.Validation.Introspection.Timeout = time.Second
+	config.OAuthProtectedResource.Resource = "https://mcp-proxy.example.com/mcp"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return NewJWTValidationMiddleware(JWTValidationMiddlewareDependencies{
+		AppCtx: &globals.ApplicationContext{
+			Context: ctx,
+			Cancel:  cancel,
+			Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Config:  config,
+		},
+	})
+}
+
+func TestJWTValidationMiddlewareIntrospection(t *testing.T) {
+	// Introspection responses are chosen by the token, to test every case with the same endpoint
+	responses := map[string]string{
+		"resource-token":  `{"active":true,"sub":"user","aud":"https://mcp-proxy.example.com/mcp"}`,
+		"audiences-token": `{"active":true,"sub":"user","aud":["other","https://mcp-proxy.example.com/mcp"]}`,
+		"other-token":     `{"active":true,"sub":"user","aud":"https://other.example.com"}`,
+		"no-aud-token":    `{"active":true,"sub":"user"}`,
+		"inactive-token":  `{"active":false}`,
+	}
+	introspectionServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		_, _ = io.WriteString(rw, responses[req.PostForm.Get("token")])
+	}))
+	t.Cleanup(introspectionServer.Close)
+
+	mw, err := newTestIntrospectionMiddleware(t, introspectionServer.URL)
+	if err != nil {
+		t.Fatalf("failed creating middleware: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		statusCode int
+	}{
+		{"audience of the resource", "resource-token", http.StatusOK},
+		{"audiences including the resource", "audiences-token", http.StatusOK},
+		{"audience of another resource", "other-token", http.StatusUnauthorized},
+		{"missing audience", "no-aud-token", http.StatusUnauthorized},
+		{"inactive token", "inactive-token", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+			request.Header.Set("Authorization", "Bearer "+test.token)
+
+			recorder := httptest.NewRecorder()
+			mw.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("got status %d, want %d", recorder.Code, test.statusCode)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareIntrospectionWithoutEndpoint(t *testing.T) {
+	if _, err := newTestIntrospectionMiddleware(t, ""); err == nil {
+		t.Error("middleware created without introspection endpoint")
+	}
+}
+
+func TestGetRequiredScopesPromptsAndResources(t *testing.T) {
+	config := &api.Configuration{}
+	config.Middleware.JWT.Scopes.Prompts = []api.JWTScopesPromptRuleConfig{
+		{Prompt: "admin_*", Scopes: []string{"mcp:admin"}},
+	}
+	config.Middleware.JWT.Scopes.Resources = []api.JWTScopesResourceRuleConfig{
+		{Resource: "file:///secrets/*", Scopes: []string{"mcp:secrets"}},
+	}
+	mw := &JWTValidationMiddleware{dependencies: JWTValidationMiddlewareDependencies{
+		AppCtx: &globals.ApplicationContext{Config: config},
+	}}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantScopes []string
+	}{
+		{"prompt completion", `{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":{"type":"ref/prompt","name":"admin_users"},"argument":{"name":"a","value":""}}}`, []string{"mcp:admin"}},
+		{"resource completion", `{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":{"type":"ref/resource","uri":"file:///secrets/{name}"},"argument":{"name":"name","value":""}}}`, []string{"mcp:secrets"}},
+		{"completion of other prompt", `{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":{"type":"ref/prompt","name":"greeting"}}}`, []string{}},
+		{"prompt", `{"jsonrpc":"2.0","id":1,"method":"prompts/get","params":{"name":"admin_users"}}`, []string{"mcp:admin"}},
+		{"resource", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///secrets/db"}}`, []string{"mcp:secrets"}},
+		{"tool named as a prompt", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"admin_users"}}`, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(test.body))
+			scopes, err := mw.getRequiredScopes(httptest.NewRecorder(), request)
+			if err != nil {
+				t.Fatalf("getRequiredScopes() error = %v", err)
+			}
+			if strings.Join(scopes, " ") != strings.Join(test.wantScopes, " ") {
+				t.Errorf("getRequiredScopes() = %v, want %v", scopes, test.wantScopes)
+			}
+		})
+	}
+}
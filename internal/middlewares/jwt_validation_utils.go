@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
@@ -9,8 +10,8 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"slices"
 	"strings"
-	"time"
 
 	//
 	"github.com/golang-jwt/jwt/v5"
@@ -33,103 +34,82 @@ type JWK struct {
 	X   string `json:"x,omitempty"`   // EC x coordinate
 	Y   string `json:"y,omitempty"`   // EC y coordinate
 
-	K   string `json:"k,omitempty"` // Symmetric key (for HMAC)
+	K   string `json:"k,omitempty"` // Symmetric key (for HMAC, never accepted)
 	Alg string `json:"alg"`
 	Use string `json:"use"`
 }
 
-// cacheJWKS obtains JWKS keys from remote, from time to time,
-// and keep internal cache reasonable up-to-date
-func (mw *JWTValidationMiddleware) cacheJWKS() {
-
-	// Bypass the cache thread when middleware is disabled by config
-	if !mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled {
-		return
-	}
-
-	mw.dependencies.AppCtx.Logger.Info("JWKS cache daemon running for JWT auth middleware")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-
-	for {
-		func() {
-			var jwks JWKS
-
-			resp, err := client.Get(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.JWKSUri)
-			if err != nil {
-				mw.dependencies.AppCtx.Logger.Error("failed getting JWKS from remote", "error", err.Error())
-				return
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				mw.dependencies.AppCtx.Logger.Error("unexpected JWKS status code", "status", resp.StatusCode)
-				return
-			}
-
-			if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-				mw.dependencies.AppCtx.Logger.Error("failed decoding JWKS from remote", "error", err.Error())
-				return
-			}
-
-			mw.mutex.Lock()
-			mw.jwks = &jwks
-			mw.mutex.Unlock()
-		}()
-
-		// Don't be greedy, man
-		time.Sleep(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.CacheInterval)
-	}
-}
-
-func (mw *JWTValidationMiddleware) isTokenValid(token string) (bool, error) {
+// validateToken verifies a JWT against the keys of its issuer, and returns the issuer when it is trusted
+func (mw *JWTValidationMiddleware) validateToken(token string) (*trustedIssuer, error) {
 	// Get JWT header
 	header, err := parseJWTHeader(token)
 	if err != nil {
-		return false, fmt.Errorf("error parsing token: %v", err)
+		return nil, fmt.Errorf("error parsing token: %v", err)
 	}
 
 	// Retrieve 'Kid' and 'Alg' from token's header
 	kid, ok := header["kid"].(string)
 	if !ok {
-		return false, fmt.Errorf("jwt header 'kid' field not found")
+		return nil, fmt.Errorf("jwt header 'kid' field not found")
 	}
 
 	alg, ok := header["alg"].(string)
 	if !ok {
-		return false, fmt.Errorf("jwt header 'alg' field not found")
+		return nil, fmt.Errorf("jwt header 'alg' field not found")
 	}
 
-	// Obtain JWKS from the middleware cache
-	mw.mutex.Lock()
-	jwks := mw.jwks
-	mw.mutex.Unlock()
+	// Find the issuer before verifying, as keys depend on it.
+	// Claims are trusted only after the signature is verified below
+	unverifiedClaims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, unverifiedClaims)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token: %v", err)
+	}
 
-	// Look for the published key with the same Kid as the token
-	var matchingKey *JWK
-	for _, key := range jwks.Keys {
-		if key.Kid == kid && (key.Use == "" || key.Use == "sig") {
-			matchingKey = &key
-			break
-		}
+	iss, ok := unverifiedClaims["iss"].(string)
+	if !ok {
+		return nil, fmt.Errorf("issuer claim not found")
 	}
 
-	if matchingKey == nil {
-		return false, fmt.Errorf("no matching 'kid' in JWKS")
+	issuer := mw.getTrustedIssuer(iss)
+	if issuer == nil {
+		return nil, fmt.Errorf("issuer not trusted")
+	}
+
+	if !mw.isAlgorithmAllowed(alg) || !issuer.isAlgorithmAllowed(alg) {
+		return nil, fmt.Errorf("algorithm not allowed for issuer")
+	}
+
+	// Look for the published key with the same Kid as the token
+	matchingKey, err := issuer.jwksProvider.GetKey(kid)
+	if err != nil {
+		return nil, err
 	}
 
 	// Algorithm must match
 	if matchingKey.Alg != "" && matchingKey.Alg != alg {
-		return false, fmt.Errorf("algorithm missmatch")
+		return nil, fmt.Errorf("algorithm missmatch")
 	}
 
 	// Convert JWK to a public key of corresponding type (RSA, EC, etc.)
 	publicKey, err := jwkToKey(matchingKey)
 	if err != nil {
-		return false, fmt.Errorf("error converting JWK to public key")
+		return nil, fmt.Errorf("error converting JWK to public key")
 	}
 
 	// Validate the token
+	localConfig := mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{alg}),
+		jwt.WithLeeway(localConfig.Leeway),
+	}
+	if slices.Contains(localConfig.RequiredClaims, "exp") {
+		parserOptions = append(parserOptions, jwt.WithExpirationRequired())
+	}
+	if slices.Contains(localConfig.RequiredClaims, "iat") {
+		parserOptions = append(parserOptions, jwt.WithIssuedAt())
+	}
+
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		//
 		expectedMethod, localErr := getSigningMethod(alg)
@@ -142,63 +122,38 @@ func (mw *JWTValidationMiddleware) isTokenValid(token string) (bool, error) {
 		}
 
 		return publicKey, nil
-	})
+	}, parserOptions...)
 
 	if err != nil || !parsedToken.Valid {
-		return false, fmt.Errorf("invalid token: %v", err)
+		return nil, fmt.Errorf("invalid token: %v", err)
 	}
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	if !ok {
-		return false, fmt.Errorf("invalid token claims type")
-	}
-
-	iss, ok := claims["iss"].(string)
-	if !ok {
-		return false, fmt.Errorf("issuer claim not found")
+		return nil, fmt.Errorf("invalid token claims type")
 	}
 
-	// Validate issuer against configured Authorization Server issuer_uri
-	expectedIssuer := mw.dependencies.AppCtx.Config.OAuthAuthorizationServer.IssuerUri
-	if expectedIssuer == "" {
-		return false, fmt.Errorf("issuer validation not configured")
-	}
-
-	// Normalize possible trailing slashes for comparison
-	normalize := func(s string) string {
-		return strings.TrimRight(s, "/")
-	}
-	if normalize(iss) != normalize(expectedIssuer) {
-		return false, fmt.Errorf("issuer mismatch")
+	for _, requiredClaim := range localConfig.RequiredClaims {
+		if _, ok := claims[requiredClaim]; !ok {
+			return nil, fmt.Errorf("required claim '%s' not found", requiredClaim)
+		}
 	}
 
 	audClaim, ok := claims["aud"]
 	if !ok {
-		return false, fmt.Errorf("audience claim not found")
+		return nil, fmt.Errorf("audience claim not found")
 	}
 
-	expectedAud := mw.dependencies.AppCtx.Config.OAuthProtectedResource.Resource
-	switch aud := audClaim.(type) {
-	case string:
-		if aud != expectedAud {
-			return false, fmt.Errorf("audience mismatch")
-		}
-	case []interface{}:
-		found := false
-		for _, a := range aud {
-			if s, ok := a.(string); ok && s == expectedAud {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return false, fmt.Errorf("audience mismatch")
-		}
-	default:
-		return false, fmt.Errorf("invalid audience claim type")
+	// Validate audience against the ones allowed for the issuer
+	if len(issuer.config.Audiences) == 0 {
+		return nil, fmt.Errorf("audience validation not configured")
 	}
 
-	return true, nil
+	if !issuer.isAudienceAllowed(audClaim) {
+		return nil, fmt.Errorf("audience mismatch")
+	}
+
+	return issuer, nil
 }
 
 // parseJWTHeader extracts the header of a JWT without verifying the signature
@@ -225,6 +180,63 @@ func parseJWTHeader(tokenString string) (map[string]interface{}, error) {
 	return header, nil
 }
 
+// getAccessToken extracts the token and its scheme from the 'Authorization' header of a request.
+// 'DPoP' scheme is only accepted when DPoP proofs are validated
+func (mw *JWTValidationMiddleware) getAccessToken(req *http.Request) (string, string, bool) {
+	authHeader := req.Header.Get("Authorization")
+
+	scheme, token, found := strings.Cut(authHeader, " ")
+	if !found || strings.TrimSpace(token) == "" {
+		return "", "", false
+	}
+
+	switch {
+	case strings.EqualFold(scheme, AuthSchemeBearer):
+		return strings.TrimSpace(token), AuthSchemeBearer, true
+	case strings.EqualFold(scheme, AuthSchemeDPoP) && mw.isDPoPEnabled():
+		return strings.TrimSpace(token), AuthSchemeDPoP, true
+	}
+
+	return "", "", false
+}
+
+// parseForwardedPayload extracts the payload from the value of the forwarded header.
+// It can be a whole JWT, or just its payload encoded in base64, as Istio does with 'outputPayloadToHeader'
+func parseForwardedPayload(headerValue string) (map[string]any, error) {
+	encodedPayload := strings.TrimPrefix(headerValue, "Bearer ")
+
+	parts := strings.Split(encodedPayload, ".")
+	switch len(parts) {
+	case 1:
+	case 3:
+		encodedPayload = parts[1]
+	default:
+		return nil, fmt.Errorf("malformed forwarded header: It must be a JWT or a base64 encoded payload")
+	}
+
+	// Padding is optional, and both alphabets are found in the wild
+	encodedPayload = strings.TrimRight(encodedPayload, "=")
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		payloadBytes, err = base64.RawStdEncoding.DecodeString(encodedPayload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding payload: %s", err.Error())
+	}
+
+	payload := map[string]any{}
+	if err = json.Unmarshal(payloadBytes, &payload); err != nil {
+		return nil, fmt.Errorf("error parsing JSON payload: %s", err.Error())
+	}
+
+	// JSON 'null' is decoded as a nil map, so empty payloads are rejected
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+
+	return payload, nil
+}
+
 // jwkToKey calculate corresponding real key (RSA, EC, etc.) from params present in the JWK
 func jwkToKey(jwk *JWK) (interface{}, error) {
 	switch jwk.Kty {
@@ -232,9 +244,11 @@ func jwkToKey(jwk *JWK) (interface{}, error) {
 		return jwkToRSAPublicKey(jwk)
 	case "EC":
 		return jwkToECPublicKey(jwk)
-	case "oct": // Symmetric keys
-		return jwkToSymmetricKey(jwk)
+	case "OKP":
+		return jwkToEdDSAPublicKey(jwk)
 	default:
+		// Symmetric keys ('oct') are rejected too, as public keys used as HMAC secrets
+		// would let anyone forge tokens (algorithm confusion)
 		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
 	}
 }
@@ -307,18 +321,26 @@ func jwkToECPublicKey(jwk *JWK) (*ecdsa.PublicKey, error) {
 	}, nil
 }
 
-// jwkToSymmetricKey converts a JWK into a simetric key (for HMAC)
-func jwkToSymmetricKey(jwk *JWK) ([]byte, error) {
-	if jwk.K == "" {
-		return nil, fmt.Errorf("incomplete symmetric key data")
+// jwkToEdDSAPublicKey converts a JWK into a public EdDSA key
+func jwkToEdDSAPublicKey(jwk *JWK) (ed25519.PublicKey, error) {
+	if jwk.X == "" || jwk.Crv == "" {
+		return nil, fmt.Errorf("incomplete OKP key data")
+	}
+
+	if jwk.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
 	}
 
-	k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding symmetric key: %v", err)
+		return nil, fmt.Errorf("error decoding X coordinate: %v", err)
+	}
+
+	if len(xBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key size")
 	}
 
-	return k, nil
+	return ed25519.PublicKey(xBytes), nil
 }
 
 // getSigningMethod returns suitable signing method according to the algorithm
@@ -336,12 +358,14 @@ func getSigningMethod(alg string) (jwt.SigningMethod, error) {
 		return jwt.SigningMethodES384, nil
 	case "ES512":
 		return jwt.SigningMethodES512, nil
-	case "HS256":
-		return jwt.SigningMethodHS256, nil
-	case "HS384":
-		return jwt.SigningMethodHS384, nil
-	case "HS512":
-		return jwt.SigningMethodHS512, nil
+	case "PS256":
+		return jwt.SigningMethodPS256, nil
+	case "PS384":
+		return jwt.SigningMethodPS384, nil
+	case "PS512":
+		return jwt.SigningMethodPS512, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
 	default:
 		return nil, fmt.Errorf("unsupported signing method: %s", alg)
 	}
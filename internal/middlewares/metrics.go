@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	//
+	"mcp-proxy/internal/globals"
+	"mcp-proxy/internal/metrics"
+)
+
+type MetricsMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+type MetricsMiddleware struct {
+	dependencies MetricsMiddlewareDependencies
+}
+
+func NewMetricsMiddleware(dependencies MetricsMiddlewareDependencies) *MetricsMiddleware {
+	return &MetricsMiddleware{
+		dependencies: dependencies,
+	}
+}
+
+// Middleware counts the requests and measures their latency.
+// Requests are labeled with the matched pattern, so it must wrap the handlers registered in the mux to keep series bounded
+func (mw *MetricsMiddleware) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: rw, statusCode: http.StatusOK}
+
+		path := req.Pattern
+		start := time.Now()
+		next.ServeHTTP(recorder, req)
+
+		metrics.HTTPRequestDuration.WithLabelValues(path, req.Method).Observe(time.Since(start).Seconds())
+		metrics.HTTPRequestsTotal.WithLabelValues(path, req.Method, strconv.Itoa(recorder.statusCode)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code sent by the next handlers.
+// Flushing is kept available, as responses can be streams
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the original writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
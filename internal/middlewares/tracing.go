@@ -0,0 +1,56 @@
+package middlewares
+
+import (
+	"net/http"
+
+	//
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	//
+	"mcp-proxy/internal/globals"
+	"mcp-proxy/internal/tracing"
+)
+
+type TracingMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+type TracingMiddleware struct {
+	dependencies TracingMiddlewareDependencies
+}
+
+func NewTracingMiddleware(dependencies TracingMiddlewareDependencies) *TracingMiddleware {
+	return &TracingMiddleware{
+		dependencies: dependencies,
+	}
+}
+
+// Middleware starts a server span for every request, continuing the trace sent by the client in 'traceparent' header.
+// Spans are named with the matched pattern, so it must wrap the handlers registered in the mux
+func (mw *TracingMiddleware) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		ctx, span := tracing.Tracer().Start(ctx, req.Method+" "+req.Pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", req.Pattern),
+				attribute.String("url.path", req.URL.Path),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: rw, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.statusCode))
+		if recorder.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.statusCode))
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"time"
+
+	//
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	//
+	"mcp-proxy/internal/tracing"
+)
+
+// CallTool executes a tool on the backend applying the configured timeouts, retries and circuit breaker.
+// Only transport errors on idempotent tools are retried, as tool errors are legit responses
+func (p *MCPProxy) CallTool(ctx context.Context, request mcp.CallToolRequest) (result *mcp.CallToolResult, err error) {
+	callsConfig := p.Dependencies.AppContext.Config.Backend.Calls
+
+	ctx, span := startBackendSpan(ctx, string(mcp.MethodToolsCall), attribute.String("mcp.tool.name", request.Params.Name))
+	defer func() {
+		if err == nil && result != nil && result.IsError {
+			span.SetStatus(codes.Error, "tool execution failed")
+		}
+		endBackendSpan(span, err)
+	}()
+
+	maxAttempts := 1
+	if callsConfig.Retry.MaxAttempts > 1 && p.isIdempotentTool(request.Params.Name) {
+		maxAttempts = callsConfig.Retry.MaxAttempts
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(getRetryBackoff(callsConfig.Retry.InitialBackoff, callsConfig.Retry.MaxBackoff, attempt)):
+			}
+
+			p.Dependencies.AppContext.Logger.Warn("retrying backend tool call",
+				"tool", request.Params.Name, "attempt", attempt+1, "error", err.Error())
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt+1)))
+		}
+
+		result, err = p.callToolOnce(ctx, request)
+		if err == nil || !isTransportError(err) || ctx.Err() != nil {
+			return result, err
+		}
+	}
+
+	return result, err
+}
+
+// callToolOnce performs a single attempt of a tool call, guarded by the circuit breaker
+func (p *MCPProxy) callToolOnce(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if p.CircuitBreaker != nil {
+		if err := p.CircuitBreaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	var attemptCtx context.Context
+	var cancel context.CancelFunc
+	if timeout := p.getToolCallTimeout(request.Params.Name); timeout > 0 {
+		attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		attemptCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	result, err := p.sendCallToolRequest(attemptCtx, request)
+
+	if p.CircuitBreaker != nil {
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Cancelled by the caller. This says nothing about the backend health
+			p.CircuitBreaker.Release()
+		case err != nil && (isTransportError(err) || attemptCtx.Err() != nil):
+			p.CircuitBreaker.RecordFailure()
+		default:
+			p.CircuitBreaker.RecordSuccess()
+		}
+	}
+
+	if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+		return nil, transport.NewError(errors.New("backend did not respond in time"))
+	}
+
+	return result, err
+}
+
+// sendCallToolRequest sends a 'tools/call' request directly to the backend transport.
+// The client is bypassed to control the request ID, which is needed to send cancellations,
+// and to replace the progress token by one that is unique across all the frontend sessions
+func (p *MCPProxy) sendCallToolRequest(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	requestId := mcp.NewRequestId(fmt.Sprintf("proxy-%d", p.backendRequestID.Add(1)))
+
+	params := request.Params
+	session := server.ClientSessionFromContext(ctx)
+	if session != nil {
+		p.BackendCallSessions.Store(requestId.String(), session)
+		defer p.BackendCallSessions.Delete(requestId.String())
+	}
+
+	if params.Meta != nil && params.Meta.ProgressToken != nil && session != nil {
+		backendToken := requestId.String()
+		p.ProgressRoutes.Store(backendToken, progressRoute{
+			Session:       session,
+			ProgressToken: params.Meta.ProgressToken,
+		})
+		defer p.ProgressRoutes.Delete(backendToken)
+
+		params.Meta = &mcp.Meta{ProgressToken: backendToken}
+	}
+	params.Meta = tracing.InjectMeta(ctx, params.Meta)
+
+	response, err := p.McpClient.GetTransport().SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      requestId,
+		Method:  string(mcp.MethodToolsCall),
+		Params:  params,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			p.sendCancelledNotification(requestId, ctx.Err().Error())
+		}
+		return nil, transport.NewError(err)
+	}
+
+	if response.Error != nil {
+		return nil, errors.New(response.Error.Message)
+	}
+
+	return mcp.ParseCallToolResult(&response.Result)
+}
+
+// getToolCallTimeout returns the timeout for calls to a tool. Zero means no timeout.
+// First rule matching the tool name wins. Default timeout is used when none matches
+func (p *MCPProxy) getToolCallTimeout(toolName string) time.Duration {
+	callsConfig := p.Dependencies.AppContext.Config.Backend.Calls
+	for _, rule := range callsConfig.TimeoutRules {
+		if matched, _ := path.Match(rule.Tool, toolName); matched {
+			return rule.Timeout
+		}
+	}
+	return *callsConfig.Timeout
+}
+
+// isIdempotentTool decides whether a tool can be safely retried according to its annotations.
+// Only known definitions are used, as callers already looked the tool up
+func (p *MCPProxy) isIdempotentTool(toolName string) bool {
+	tool, exists := p.LookupBackendTool(toolName)
+	if !exists {
+		return false
+	}
+
+	annotations := tool.Annotations
+	return (annotations.ReadOnlyHint != nil && *annotations.ReadOnlyHint) ||
+		(annotations.IdempotentHint != nil && *annotations.IdempotentHint)
+}
+
+// isTransportError decides whether an error happened in the transport layer,
+// as opposed to errors returned by the backend through JSON-RPC
+func isTransportError(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr)
+}
+
+// getRetryBackoff returns an exponential backoff with full jitter for the given attempt
+func getRetryBackoff(initial, max time.Duration, attempt int) time.Duration {
+	backoff := initial << (attempt - 1)
+	if backoff <= 0 || backoff > max {
+		backoff = max
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
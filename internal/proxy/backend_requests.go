@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// CreateMessage forwards 'sampling/createMessage' requests from the backend to the frontend client
+// that originated the call. It implements client.SamplingHandler
+func (p *MCPProxy) CreateMessage(ctx context.Context, request mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+	session, err := p.getOriginatingSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if capabilities, known := getSessionCapabilities(session); !known || capabilities.Sampling == nil {
+		return nil, fmt.Errorf("frontend client does not support sampling")
+	}
+
+	return p.McpServer.RequestSampling(p.McpServer.WithContext(ctx, session), request)
+}
+
+// Elicit forwards 'elicitation/create' requests from the backend to the frontend client
+// that originated the call. It implements client.ElicitationHandler
+func (p *MCPProxy) Elicit(ctx context.Context, request mcp.ElicitationRequest) (*mcp.ElicitationResult, error) {
+	session, err := p.getOriginatingSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if capabilities, known := getSessionCapabilities(session); !known || capabilities.Elicitation == nil {
+		return nil, fmt.Errorf("frontend client does not support elicitation")
+	}
+
+	return p.McpServer.RequestElicitation(p.McpServer.WithContext(ctx, session), request)
+}
+
+// ListRoots answers 'roots/list' requests from the backend with the static roots, when configured,
+// or with the roots of the frontend client that originated the call. It implements client.RootsHandler
+func (p *MCPProxy) ListRoots(ctx context.Context, request mcp.ListRootsRequest) (*mcp.ListRootsResult, error) {
+	staticRoots := p.Dependencies.AppContext.Config.Backend.Roots
+	if len(staticRoots) > 0 {
+		result := &mcp.ListRootsResult{}
+		for _, root := range staticRoots {
+			result.Roots = append(result.Roots, mcp.Root{URI: root.URI, Name: root.Name})
+		}
+		return result, nil
+	}
+
+	session, err := p.getOriginatingSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if capabilities, known := getSessionCapabilities(session); !known || capabilities.Roots == nil {
+		return nil, fmt.Errorf("frontend client does not support roots")
+	}
+
+	return p.McpServer.RequestRoots(p.McpServer.WithContext(ctx, session), request)
+}
+
+// getOriginatingSession returns the frontend session that caused a request coming from the backend.
+// HTTP backends send those requests in the stream of the originating call, so the session travels in the context.
+// Requests not tied to a call, such as the ones of stdio backends, are never guessed to belong to any session
+func (p *MCPProxy) getOriginatingSession(ctx context.Context) (server.ClientSession, error) {
+	if p.McpServer == nil {
+		return nil, fmt.Errorf("frontend MCP server is not ready")
+	}
+
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return nil, fmt.Errorf("unable to relate the request to a frontend session")
+	}
+	return session, nil
+}
+
+// getSessionCapabilities returns the capabilities declared by a frontend client.
+// They are unknown for sessions that did not store the 'initialize' request, such as ephemeral HTTP ones.
+// Requests are only relayed to clients that declared the related capability, so unknown ones are rejected
+func getSessionCapabilities(session server.ClientSession) (mcp.ClientCapabilities, bool) {
+	sessionWithClientInfo, ok := session.(server.SessionWithClientInfo)
+	if !ok || sessionWithClientInfo.GetClientInfo().Name == "" {
+		return mcp.ClientCapabilities{}, false
+	}
+
+	return sessionWithClientInfo.GetClientCapabilities(), true
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// maxBackendToolsPages limits the pages of tools asked to the backend in a single listing
+	maxBackendToolsPages = 100
+
+	// minBackendToolsRefreshInterval limits how often unknown tools trigger a listing,
+	// so clients calling made-up names can not flood the backend
+	minBackendToolsRefreshInterval = 10 * time.Second
+)
+
+// StoreBackendTools replaces the known definitions of the backend tools
+func (p *MCPProxy) StoreBackendTools(tools []mcp.Tool) {
+	registry := make(map[string]mcp.Tool, len(tools))
+	for _, tool := range tools {
+		registry[tool.Name] = tool
+	}
+
+	p.BackendToolsMu.Lock()
+	p.BackendTools = registry
+	p.backendToolsListedAt = time.Now()
+	p.BackendToolsMu.Unlock()
+}
+
+// ForgetBackendTools drops the known definitions of the backend tools, so they are listed again on next lookup
+func (p *MCPProxy) ForgetBackendTools() {
+	p.BackendToolsMu.Lock()
+	p.BackendTools = map[string]mcp.Tool{}
+	p.backendToolsListedAt = time.Time{}
+	p.BackendToolsMu.Unlock()
+}
+
+// LookupBackendTool returns the known definition of a backend tool, without asking the backend
+func (p *MCPProxy) LookupBackendTool(name string) (mcp.Tool, bool) {
+	p.BackendToolsMu.RLock()
+	defer p.BackendToolsMu.RUnlock()
+
+	tool, exists := p.BackendTools[name]
+	return tool, exists
+}
+
+// GetBackendTool returns the definition of a backend tool.
+// Definitions are refreshed from the backend when the tool is not known yet, at most once per refresh interval,
+// so unknown tools are not listed again until then
+func (p *MCPProxy) GetBackendTool(ctx context.Context, name string) (mcp.Tool, bool, error) {
+	if tool, exists := p.LookupBackendTool(name); exists {
+		return tool, true, nil
+	}
+
+	// Concurrent lookups wait for a single listing
+	p.backendToolsRefreshMu.Lock()
+	defer p.backendToolsRefreshMu.Unlock()
+
+	p.BackendToolsMu.RLock()
+	tool, exists := p.BackendTools[name]
+	listedAt := p.backendToolsListedAt
+	p.BackendToolsMu.RUnlock()
+
+	if exists || time.Since(listedAt) < minBackendToolsRefreshInterval {
+		return tool, exists, nil
+	}
+
+	// Failed listings are rate limited too, as the backend may be struggling
+	p.BackendToolsMu.Lock()
+	p.backendToolsListedAt = time.Now()
+	p.BackendToolsMu.Unlock()
+
+	if err := p.InitializeBackend(ctx); err != nil {
+		return tool, false, err
+	}
+
+	if _, err := p.ListBackendTools(ctx); err != nil {
+		return tool, false, err
+	}
+
+	tool, exists = p.LookupBackendTool(name)
+	return tool, exists, nil
+}
+
+// ListBackendTools asks the backend for all its tools, following every page, and refreshes the known definitions.
+// Returned result carries the tools of all the pages, without cursor
+func (p *MCPProxy) ListBackendTools(ctx context.Context) (result *mcp.ListToolsResult, err error) {
+	ctx, span := startBackendSpan(ctx, string(mcp.MethodToolsList))
+	defer func() { endBackendSpan(span, err) }()
+
+	request := mcp.ListToolsRequest{}
+	seenCursors := map[mcp.Cursor]bool{}
+
+	for page := 1; ; page++ {
+		pageResult, err := p.McpClient.ListTools(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = pageResult
+		} else {
+			result.Tools = append(result.Tools, pageResult.Tools...)
+		}
+
+		if pageResult.NextCursor == "" {
+			break
+		}
+
+		// Backends repeating cursors would be listed forever
+		if seenCursors[pageResult.NextCursor] || page >= maxBackendToolsPages {
+			return nil, fmt.Errorf("backend tools list does not end after %d pages", page)
+		}
+		seenCursors[pageResult.NextCursor] = true
+		request.Params.Cursor = pageResult.NextCursor
+	}
+
+	result.NextCursor = ""
+	p.StoreBackendTools(result.Tools)
+
+	return result, nil
+}
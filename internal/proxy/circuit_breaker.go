@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitBreakerStateClosed   = "closed"
+	CircuitBreakerStateOpen     = "open"
+	CircuitBreakerStateHalfOpen = "half-open"
+)
+
+// CircuitBreaker fails fast while the backend is unhealthy.
+// It opens after several consecutive failures and, once the open duration is over,
+// lets a single probe call pass through to decide whether to close again
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	failureThreshold int
+	openDuration     time.Duration
+
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	probeInFlight       bool
+}
+
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		state:            CircuitBreakerStateClosed,
+	}
+}
+
+// Allow returns an error when calls must not reach the backend
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitBreakerStateOpen:
+		remaining := cb.openDuration - time.Since(cb.openedAt)
+		if remaining > 0 {
+			return fmt.Errorf("circuit breaker is open: backend is unhealthy, retry in %s", remaining.Round(time.Second))
+		}
+		cb.state = CircuitBreakerStateHalfOpen
+		cb.probeInFlight = true
+		return nil
+
+	case CircuitBreakerStateHalfOpen:
+		if cb.probeInFlight {
+			return fmt.Errorf("circuit breaker is half-open: waiting for the backend health probe")
+		}
+		cb.probeInFlight = true
+		return nil
+	}
+
+	return nil
+}
+
+// RecordSuccess closes the circuit
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = CircuitBreakerStateClosed
+	cb.consecutiveFailures = 0
+	cb.probeInFlight = false
+}
+
+// RecordFailure opens the circuit when the probe fails or too many consecutive failures happened
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveFailures++
+	cb.probeInFlight = false
+
+	if cb.state == CircuitBreakerStateHalfOpen || cb.consecutiveFailures >= cb.failureThreshold {
+		cb.state = CircuitBreakerStateOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// Release frees the probe slot without judging the backend health.
+// It is used when calls are cancelled by the caller
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probeInFlight = false
+}
+
+// State returns the current state of the circuit
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.state
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// CompletePromptArgument forwards argument autocompletion of prompts to the backend.
+// It implements server.PromptCompletionProvider
+func (p *MCPProxy) CompletePromptArgument(ctx context.Context, promptName string,
+	argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+
+	return p.complete(ctx, mcp.PromptReference{Type: "ref/prompt", Name: promptName}, argument, completeContext)
+}
+
+// CompleteResourceArgument forwards argument autocompletion of resource templates to the backend.
+// It implements server.ResourceCompletionProvider
+func (p *MCPProxy) CompleteResourceArgument(ctx context.Context, uri string,
+	argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+
+	return p.complete(ctx, mcp.ResourceReference{Type: "ref/resource", URI: uri}, argument, completeContext)
+}
+
+// complete sends a 'completion/complete' request to the backend.
+// Scopes of the completed prompt or resource were already required by the JWT validation middleware.
+// Empty completions are returned when the backend does not support them
+func (p *MCPProxy) complete(ctx context.Context, ref any,
+	argument mcp.CompleteArgument, completeContext mcp.CompleteContext) (*mcp.Completion, error) {
+
+	if err := p.InitializeBackend(ctx); err != nil {
+		return nil, err
+	}
+
+	if p.McpClient.GetServerCapabilities().Completions == nil {
+		return &mcp.Completion{Values: []string{}}, nil
+	}
+
+	request := mcp.CompleteRequest{}
+	request.Params.Ref = ref
+	request.Params.Argument = argument
+	request.Params.Context = completeContext
+
+	result, err := p.McpClient.Complete(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result.Completion, nil
+}
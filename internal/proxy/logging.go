@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// loggingLevelSeverity represents the severity of MCP logging levels (RFC 5424), from most to least verbose
+var loggingLevelSeverity = map[mcp.LoggingLevel]int{
+	mcp.LoggingLevelDebug:     0,
+	mcp.LoggingLevelInfo:      1,
+	mcp.LoggingLevelNotice:    2,
+	mcp.LoggingLevelWarning:   3,
+	mcp.LoggingLevelError:     4,
+	mcp.LoggingLevelCritical:  5,
+	mcp.LoggingLevelAlert:     6,
+	mcp.LoggingLevelEmergency: 7,
+}
+
+// relayLogNotification mirrors backend log messages into the proxy logger and forwards them to the clients.
+// As the backend connection is shared, messages only go to the session owning the request they are related to.
+// Messages not related to any request are only logged. Sessions only receive messages above their own level
+func (p *MCPProxy) relayLogNotification(notification mcp.JSONRPCNotification) {
+	level, _ := notification.Params.AdditionalFields["level"].(string)
+	logger, _ := notification.Params.AdditionalFields["logger"].(string)
+	data := notification.Params.AdditionalFields["data"]
+
+	p.Dependencies.AppContext.Logger.Log(p.Dependencies.AppContext.Context, getSlogLevel(mcp.LoggingLevel(level)),
+		"backend log message", "backend", p.BackendInfo.Name, "logger", logger, "data", data)
+
+	if p.McpServer == nil {
+		return
+	}
+
+	session := p.getNotificationSession(notification)
+	if session == nil {
+		return
+	}
+
+	logNotification := mcp.NewLoggingMessageNotification(mcp.LoggingLevel(level), logger, data)
+	sessionCtx := p.McpServer.WithContext(p.Dependencies.AppContext.Context, session)
+	err := p.McpServer.SendLogMessageToClient(sessionCtx, logNotification)
+	if err != nil && !errors.Is(err, server.ErrSessionDoesNotSupportLogging) {
+		p.Dependencies.AppContext.Logger.Warn("failed relaying log message to client",
+			"session", session.SessionID(), "error", err.Error())
+	}
+}
+
+// getNotificationSession returns the frontend session owning the backend request a notification is related to,
+// found by the request ID or the progress token in its '_meta'. It is nil when the notification has no owner
+func (p *MCPProxy) getNotificationSession(notification mcp.JSONRPCNotification) server.ClientSession {
+	meta := notification.Params.Meta
+	if meta == nil {
+		return nil
+	}
+
+	if requestId, ok := meta[relatedRequestIdMetaField]; ok {
+		if session, found := p.BackendCallSessions.Load(fmt.Sprint(requestId)); found {
+			return session.(server.ClientSession)
+		}
+	}
+
+	if progressToken, ok := meta[relatedProgressTokenMetaField]; ok {
+		if route, found := p.ProgressRoutes.Load(fmt.Sprint(progressToken)); found {
+			return route.(progressRoute).Session
+		}
+	}
+
+	return nil
+}
+
+// raiseBackendLogLevel asks the backend for a more verbose log level when a client requests it.
+// The level is never lowered, as the backend is shared and the proxy filters messages per session
+func (p *MCPProxy) raiseBackendLogLevel(ctx context.Context, level mcp.LoggingLevel) {
+	p.BackendLogLevelMu.Lock()
+	defer p.BackendLogLevelMu.Unlock()
+
+	currentSeverity, isSet := loggingLevelSeverity[p.BackendLogLevel]
+	if isSet && loggingLevelSeverity[level] >= currentSeverity {
+		return
+	}
+
+	if err := p.setBackendLogLevel(ctx, level); err != nil {
+		p.Dependencies.AppContext.Logger.Warn("failed setting log level on backend", "level", level, "error", err.Error())
+	}
+}
+
+// setBackendLogLevel sends 'logging/setLevel' to the backend, when it supports logging
+func (p *MCPProxy) setBackendLogLevel(ctx context.Context, level mcp.LoggingLevel) error {
+	if p.McpClient == nil || p.McpClient.GetServerCapabilities().Logging == nil {
+		return nil
+	}
+
+	request := mcp.SetLevelRequest{}
+	request.Params.Level = level
+	if err := p.McpClient.SetLevel(ctx, request); err != nil {
+		return err
+	}
+
+	p.BackendLogLevel = level
+	return nil
+}
+
+// getSlogLevel returns the slog level matching an MCP logging level
+func getSlogLevel(level mcp.LoggingLevel) slog.Level {
+	switch level {
+	case mcp.LoggingLevelDebug:
+		return slog.LevelDebug
+	case mcp.LoggingLevelInfo, mcp.LoggingLevelNotice:
+		return slog.LevelInfo
+	case mcp.LoggingLevelWarning:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
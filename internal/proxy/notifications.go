@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	MethodNotificationProgress  = "notifications/progress"
+	MethodNotificationCancelled = "notifications/cancelled"
+	MethodNotificationMessage   = "notifications/message"
+
+	// Fields of the request '_meta' used to carry the JSON-RPC ID and the proxy-generated key
+	// of frontend tool calls from the hooks to the handlers. They are never sent to the backend
+	requestIdMetaField  = "mcp-proxy/requestId"
+	requestKeyMetaField = "mcp-proxy/requestKey"
+
+	// Fields of the notification '_meta' used by backends to tell the request a message is related to
+	relatedRequestIdMetaField     = "requestId"
+	relatedProgressTokenMetaField = "progressToken"
+)
+
+// progressRoute represents the frontend destination of the progress notifications
+// sent by the backend for a single request.
+// Sessions are kept, instead of their IDs, as HTTP ones only live during the request
+type progressRoute struct {
+	Session       server.ClientSession
+	ProgressToken mcp.ProgressToken
+}
+
+// inFlightCall represents a frontend tool call that is being executed
+type inFlightCall struct {
+	Session   server.ClientSession
+	RequestID any
+	Cancel    context.CancelFunc
+}
+
+// RegisterHooks registers the hooks needed by the proxy into the frontend MCP server hooks
+func (p *MCPProxy) RegisterHooks(hooks *server.Hooks) {
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		p.Sessions.Store(session.SessionID(), session)
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		p.Sessions.Delete(session.SessionID())
+	})
+	hooks.AddAfterSetLevel(func(ctx context.Context, id any, message *mcp.SetLevelRequest, result *mcp.EmptyResult) {
+		p.raiseBackendLogLevel(ctx, message.Params.Level)
+	})
+
+	// Tool calls are tagged before the tracing hooks run, as their spans are stored under the request key
+	hooks.AddBeforeAny(func(ctx context.Context, id any, method mcp.MCPMethod, message any) {
+		callToolRequest, ok := message.(*mcp.CallToolRequest)
+		if !ok {
+			return
+		}
+
+		if callToolRequest.Params.Meta == nil {
+			callToolRequest.Params.Meta = &mcp.Meta{}
+		}
+		if callToolRequest.Params.Meta.AdditionalFields == nil {
+			callToolRequest.Params.Meta.AdditionalFields = map[string]any{}
+		}
+		callToolRequest.Params.Meta.AdditionalFields[requestIdMetaField] = id
+		callToolRequest.Params.Meta.AdditionalFields[requestKeyMetaField] = p.frontendRequestKey.Add(1)
+	})
+
+	p.registerTracingHooks(hooks)
+}
+
+// RegisterNotificationHandlers registers the handlers for the notifications sent by frontend clients
+func (p *MCPProxy) RegisterNotificationHandlers() {
+	p.McpServer.AddNotificationHandler(MethodNotificationCancelled, p.handleCancelledNotification)
+	p.McpServer.AddNotificationHandler(mcp.MethodNotificationRootsListChanged, p.handleRootsListChangedNotification)
+}
+
+// TrackCall makes a frontend tool call cancellable by 'notifications/cancelled'.
+// Returned function must be called when the call is finished
+func (p *MCPProxy) TrackCall(ctx context.Context, request mcp.CallToolRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	if request.Params.Meta == nil || request.Params.Meta.AdditionalFields[requestKeyMetaField] == nil {
+		return ctx, cancel
+	}
+
+	callKey := request.Params.Meta.AdditionalFields[requestKeyMetaField]
+	p.InFlightCalls.Store(callKey, inFlightCall{
+		Session:   server.ClientSessionFromContext(ctx),
+		RequestID: request.Params.Meta.AdditionalFields[requestIdMetaField],
+		Cancel:    cancel,
+	})
+
+	return ctx, func() {
+		p.InFlightCalls.Delete(callKey)
+		cancel()
+	}
+}
+
+// handleCancelledNotification cancels the in-flight frontend call referenced by the notification.
+// Cancellation is propagated to the backend as the backend request is bound to that context.
+// Calls are only looked up within the session of the notification, so requests without session can not be cancelled
+func (p *MCPProxy) handleCancelledNotification(ctx context.Context, notification mcp.JSONRPCNotification) {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil || session.SessionID() == "" {
+		return
+	}
+
+	requestId, ok := notification.Params.AdditionalFields["requestId"]
+	if !ok {
+		return
+	}
+
+	p.InFlightCalls.Range(func(_, value any) bool {
+		call := value.(inFlightCall)
+		if call.Session == nil || call.Session.SessionID() != session.SessionID() ||
+			fmt.Sprint(call.RequestID) != fmt.Sprint(requestId) {
+			return true
+		}
+
+		p.Dependencies.AppContext.Logger.Info("tool call cancelled by client",
+			"session", session.SessionID(), "request_id", requestId, "reason", notification.Params.AdditionalFields["reason"])
+		call.Cancel()
+		return false
+	})
+}
+
+// handleRootsListChangedNotification relays roots changes of the clients to the backend.
+// Changes are not relayed when static roots are configured, as clients' roots are not used
+func (p *MCPProxy) handleRootsListChangedNotification(ctx context.Context, notification mcp.JSONRPCNotification) {
+	if len(p.Dependencies.AppContext.Config.Backend.Roots) > 0 || !p.Dependencies.AppContext.Config.Backend.Capabilities.Roots {
+		return
+	}
+
+	p.Mu.RLock()
+	initialized := p.Initialized
+	p.Mu.RUnlock()
+
+	if !initialized {
+		return
+	}
+
+	err := p.McpClient.RootListChanges(ctx)
+	if err != nil {
+		p.Dependencies.AppContext.Logger.Warn("failed relaying roots list changes to backend", "error", err.Error())
+	}
+}
+
+// handleBackendNotification processes the notifications sent by the backend
+func (p *MCPProxy) handleBackendNotification(notification mcp.JSONRPCNotification) {
+	switch notification.Method {
+	case MethodNotificationProgress:
+		p.relayProgressNotification(notification)
+
+	case MethodNotificationMessage:
+		p.relayLogNotification(notification)
+
+	case mcp.MethodNotificationToolsListChanged:
+		p.ForgetBackendTools()
+	}
+}
+
+// relayProgressNotification sends backend progress to the frontend session that requested it,
+// restoring the progress token used by the client
+func (p *MCPProxy) relayProgressNotification(notification mcp.JSONRPCNotification) {
+	backendToken := notification.Params.AdditionalFields["progressToken"]
+
+	route, ok := p.ProgressRoutes.Load(fmt.Sprint(backendToken))
+	if !ok || p.McpServer == nil {
+		return
+	}
+
+	params := make(map[string]any, len(notification.Params.AdditionalFields))
+	for key, value := range notification.Params.AdditionalFields {
+		params[key] = value
+	}
+	params["progressToken"] = route.(progressRoute).ProgressToken
+
+	sessionCtx := p.McpServer.WithContext(p.Dependencies.AppContext.Context, route.(progressRoute).Session)
+	err := p.McpServer.SendNotificationToClient(sessionCtx, MethodNotificationProgress, params)
+	if err != nil {
+		p.Dependencies.AppContext.Logger.Warn("failed relaying progress notification to client",
+			"session", route.(progressRoute).Session.SessionID(), "error", err.Error())
+	}
+}
+
+// sendCancelledNotification tells the backend to stop working on a request
+func (p *MCPProxy) sendCancelledNotification(requestId mcp.RequestId, reason string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(p.Dependencies.AppContext.Context), 5*time.Second)
+	defer cancel()
+
+	notification := mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: MethodNotificationCancelled,
+			Params: mcp.NotificationParams{
+				AdditionalFields: map[string]any{
+					"requestId": requestId.Value(),
+					"reason":    reason,
+				},
+			},
+		},
+	}
+
+	err := p.McpClient.GetTransport().SendNotification(ctx, notification)
+	if err != nil {
+		p.Dependencies.AppContext.Logger.Warn("failed sending cancellation to backend",
+			"request_id", requestId.String(), "error", err.Error())
+	}
+}
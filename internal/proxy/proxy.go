@@ -12,15 +12,27 @@ import (
 
 	//
 	"mcp-proxy/internal/cache"
+	"mcp-proxy/internal/metrics"
+	"mcp-proxy/internal/tracing"
 )
 
 func NewMCPProxy(deps MCPProxyDependencies) *MCPProxy {
 
 	tmpCache := cache.NewCache()
-	return &MCPProxy{
+	resultsCacheConfig := deps.AppContext.Config.Backend.ToolResultsCache
+	pxy := &MCPProxy{
 		Dependencies: deps,
 		Cache:        tmpCache,
+		ResultsCache: cache.NewResultsCache(resultsCacheConfig.MaxEntries, resultsCacheConfig.MaxBytes),
+		BackendTools: map[string]mcp.Tool{},
 	}
+
+	circuitBreakerConfig := deps.AppContext.Config.Backend.Calls.CircuitBreaker
+	if circuitBreakerConfig.Enabled {
+		pxy.CircuitBreaker = NewCircuitBreaker(circuitBreakerConfig.FailureThreshold, circuitBreakerConfig.OpenDuration)
+	}
+
+	return pxy
 }
 
 // InitializeBackend init the connection with the backend MCP
@@ -32,16 +44,29 @@ func (p *MCPProxy) InitializeBackend(ctx context.Context) (err error) {
 		return nil
 	}
 
-	var mcpClient *client.Client
+	// Every attempt is counted, so reconnections are visible
+	defer func() {
+		if err != nil {
+			metrics.BackendConnectionsTotal.WithLabelValues("error").Inc()
+			return
+		}
+		metrics.BackendConnectionsTotal.WithLabelValues("success").Inc()
+	}()
+
+	var backendTransport transport.Interface
 	switch p.Dependencies.AppContext.Config.Backend.Transport.Type {
 	case "http":
-		mcpClient, err = client.NewStreamableHttpClient(p.Dependencies.AppContext.Config.Backend.Transport.HTTP.URL,
+		backendTransport, err = transport.NewStreamableHTTP(p.Dependencies.AppContext.Config.Backend.Transport.HTTP.URL,
 			[]transport.StreamableHTTPCOption{
 				transport.WithHTTPHeaders(p.Dependencies.AppContext.Config.Backend.Transport.HTTP.Headers),
+				// Trace context of every request is propagated to the backend when tracing is enabled
+				transport.WithHTTPHeaderFunc(tracing.GetHTTPHeaders),
+				// Notifications not related to any request (logs, list changes, etc.) arrive through this stream
+				transport.WithContinuousListening(),
 				//transport.WithSession("custom_session"),
 			}...)
 	default:
-		mcpClient, err = client.NewStdioMCPClient(p.Dependencies.AppContext.Config.Backend.Transport.Stdio.Command,
+		backendTransport = transport.NewStdio(p.Dependencies.AppContext.Config.Backend.Transport.Stdio.Command,
 			p.Dependencies.AppContext.Config.Backend.Transport.Stdio.Env,
 			p.Dependencies.AppContext.Config.Backend.Transport.Stdio.Args...)
 	}
@@ -50,6 +75,32 @@ func (p *MCPProxy) InitializeBackend(ctx context.Context) (err error) {
 		return fmt.Errorf("failed creating backend MCP client: %s", err.Error())
 	}
 
+	// Requests coming from the backend are forwarded to the frontend clients.
+	// The client declares the related capabilities when their handlers are set. As the connection is shared,
+	// handlers check the originating client declared them too, answering a JSON-RPC error otherwise.
+	// Only HTTP backends send them within the stream of the originating call, so the ones of stdio backends
+	// are rejected, but 'roots/list' when static roots are configured
+	var clientOptions []client.ClientOption
+	if p.Dependencies.AppContext.Config.Backend.Capabilities.Sampling {
+		clientOptions = append(clientOptions, client.WithSamplingHandler(p))
+	}
+	if p.Dependencies.AppContext.Config.Backend.Capabilities.Elicitation {
+		clientOptions = append(clientOptions, client.WithElicitationHandler(p))
+	}
+	if p.Dependencies.AppContext.Config.Backend.Capabilities.Roots || len(p.Dependencies.AppContext.Config.Backend.Roots) > 0 {
+		clientOptions = append(clientOptions, client.WithRootsHandler(p))
+	}
+
+	mcpClient := client.NewClient(backendTransport, clientOptions...)
+	mcpClient.OnNotification(p.handleBackendNotification)
+
+	// Start the transport with the application context, as the calling one can be short-lived.
+	// For stdio, this is the context the child process is bound to
+	err = mcpClient.Start(p.Dependencies.AppContext.Context)
+	if err != nil {
+		return fmt.Errorf("failed starting backend MCP client: %s", err.Error())
+	}
+
 	// Init connection
 	initRequest := mcp.InitializeRequest{}
 	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
@@ -57,20 +108,49 @@ func (p *MCPProxy) InitializeBackend(ctx context.Context) (err error) {
 		Name:    "backend",
 		Version: "1.0.0",
 	}
+	// Sampling, elicitation and roots capabilities are added by the client from the handlers set above
 	initRequest.Params.Capabilities = mcp.ClientCapabilities{
 		Experimental: make(map[string]interface{}),
-		Roots:        nil,
-		Sampling:     nil,
 	}
 
-	_, err = mcpClient.Initialize(ctx, initRequest)
+	initResult, err := mcpClient.Initialize(ctx, initRequest)
 	if err != nil {
+		_ = mcpClient.Close()
 		return fmt.Errorf("failed to initialize backend connection: %w", err)
 	}
 
 	p.McpClient = mcpClient
+	p.BackendInfo = initResult.ServerInfo
 	p.Initialized = true
 
+	// Ask for backend logs from the beginning, so they are mirrored into the proxy logs
+	if level := p.Dependencies.AppContext.Config.Backend.Logging.Level; level != "" {
+		p.raiseBackendLogLevel(ctx, mcp.LoggingLevel(level))
+	}
+
 	log.Printf("Successfully connected to backend MCP server")
 	return nil
 }
+
+// PingBackend checks the backend MCP answers requests
+func (p *MCPProxy) PingBackend(ctx context.Context) error {
+	p.Mu.RLock()
+	initialized := p.Initialized
+	mcpClient := p.McpClient
+	p.Mu.RUnlock()
+
+	if !initialized {
+		return fmt.Errorf("backend connection not initialized")
+	}
+
+	return mcpClient.Ping(ctx)
+}
+
+// CheckBackend initializes the backend connection when needed, then checks it answers requests.
+// Both steps run in order, so the first check does not fail while the connection is still being initialized
+func (p *MCPProxy) CheckBackend(ctx context.Context) error {
+	if err := p.InitializeBackend(ctx); err != nil {
+		return err
+	}
+	return p.PingBackend(ctx)
+}
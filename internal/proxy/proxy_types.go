@@ -2,9 +2,12 @@ package proxy
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	//
 	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 
 	//
@@ -24,9 +27,45 @@ type MCPProxy struct {
 	Mu sync.RWMutex
 
 	//
-	McpServer *server.MCPServer
-	McpClient *client.Client
-	Cache     *cache.Cache
+	McpServer    *server.MCPServer
+	McpClient    *client.Client
+	Cache        *cache.Cache
+	ResultsCache *cache.ResultsCache
+
+	// Only present when enabled by config
+	CircuitBreaker *CircuitBreaker
+
+	// Definitions of the tools exposed by the backend, indexed by name
+	BackendToolsMu sync.RWMutex
+	BackendTools   map[string]mcp.Tool
+
+	// Last listing of the backend tools, or attempt of it, protected by BackendToolsMu.
+	// Lookups of unknown tools list them one at a time
+	backendToolsListedAt  time.Time
+	backendToolsRefreshMu sync.Mutex
+
+	// Frontend tool calls that can be cancelled, and destinations of the backend progress notifications
+	InFlightCalls  sync.Map // request key --> inFlightCall
+	ProgressRoutes sync.Map // backend progress token --> progressRoute
+
+	// Frontend sessions owning the requests sent to the backend, to route the messages related to them
+	BackendCallSessions sync.Map // backend request ID --> server.ClientSession
+
+	// Spans of the frontend requests being handled
+	RequestSpans sync.Map // request key --> trace.Span
+
+	// Frontend sessions registered in the MCP server, and the log level requested to the backend
+	Sessions          sync.Map // sessionID --> server.ClientSession
+	BackendLogLevelMu sync.Mutex
+	BackendLogLevel   mcp.LoggingLevel
+	BackendInfo       mcp.Implementation
+
+	// Counter for the IDs of the requests sent directly to the backend transport
+	backendRequestID atomic.Int64
+
+	// Counter for the keys of the frontend tool calls. Their JSON-RPC IDs are only unique within a session,
+	// and requests without session would collide otherwise
+	frontendRequestKey atomic.Int64
 
 	//
 	BackendURL  string
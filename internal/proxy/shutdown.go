@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// drainPollInterval is how often in-flight calls are checked while draining
+	drainPollInterval = 100 * time.Millisecond
+
+	// drainCancelGracePeriod is the time given to cancelled calls to tell the backend and return
+	drainCancelGracePeriod = 2 * time.Second
+
+	// backendCloseTimeout is the time given to the backend to close the connection, or exit for stdio ones
+	backendCloseTimeout = 3 * time.Second
+
+	// backendKillGracePeriod is the time given to the backend to exit once it is killed
+	backendKillGracePeriod = 2 * time.Second
+)
+
+// DrainCalls waits for the in-flight tool calls to finish.
+// Calls still running when the context is done are cancelled, so the backend is told to stop them
+func (p *MCPProxy) DrainCalls(ctx context.Context) {
+	if p.waitInFlightCalls(ctx) {
+		return
+	}
+
+	pendingCalls := 0
+	p.InFlightCalls.Range(func(_, call any) bool {
+		pendingCalls++
+		call.(inFlightCall).Cancel()
+		return true
+	})
+	p.Dependencies.AppContext.Logger.Warn("cancelled tool calls not finished in time", "calls", pendingCalls)
+
+	graceCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainCancelGracePeriod)
+	defer cancel()
+	p.waitInFlightCalls(graceCtx)
+}
+
+// waitInFlightCalls waits until there are no in-flight tool calls. It returns false when the context is done before
+func (p *MCPProxy) waitInFlightCalls(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pending := false
+		p.InFlightCalls.Range(func(_, _ any) bool {
+			pending = true
+			return false
+		})
+
+		if !pending {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// CloseBackend closes the connection with the backend MCP.
+// Stdio backends are expected to exit when their stdin is closed. They are killed when they do not do it in time,
+// by cancelling the application context the process is bound to
+func (p *MCPProxy) CloseBackend() error {
+	p.Mu.Lock()
+	initialized := p.Initialized
+	mcpClient := p.McpClient
+	p.Initialized = false
+	p.Mu.Unlock()
+
+	if !initialized {
+		return nil
+	}
+
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- mcpClient.Close()
+	}()
+
+	select {
+	case err := <-closeErr:
+		return err
+	case <-time.After(backendCloseTimeout):
+	}
+
+	p.Dependencies.AppContext.Logger.Warn("backend did not close in time, terminating it")
+	p.Dependencies.AppContext.Cancel()
+
+	select {
+	case <-closeErr:
+		return fmt.Errorf("backend terminated after not closing in time")
+	case <-time.After(backendKillGracePeriod):
+		return fmt.Errorf("backend did not exit after being terminated")
+	}
+}
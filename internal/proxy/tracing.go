@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	//
+	"mcp-proxy/internal/tracing"
+)
+
+// registerTracingHooks registers the hooks that trace every JSON-RPC request of the frontend clients.
+// Spans live in RequestSpans from the first hook to the last one, as hooks can not change the request context
+func (p *MCPProxy) registerTracingHooks(hooks *server.Hooks) {
+	hooks.AddBeforeAny(p.startRequestSpan)
+
+	hooks.AddOnSuccess(func(ctx context.Context, id any, method mcp.MCPMethod, message any, result any) {
+		p.endRequestSpan(message, nil)
+	})
+	hooks.AddOnError(func(ctx context.Context, id any, method mcp.MCPMethod, message any, err error) {
+		p.endRequestSpan(message, err)
+	})
+}
+
+// getRequestSpanKey returns the key of the span of a frontend request.
+// Tool calls carry their proxy-generated key, so their handlers find it too.
+// Other requests are decoded once and handed to every hook, so their message identifies them
+func getRequestSpanKey(message any) any {
+	if callToolRequest, ok := message.(*mcp.CallToolRequest); ok && callToolRequest.Params.Meta != nil {
+		if requestKey, ok := callToolRequest.Params.Meta.AdditionalFields[requestKeyMetaField]; ok {
+			return requestKey
+		}
+	}
+	return message
+}
+
+// startRequestSpan starts the span of a frontend request.
+// Trace context in the request '_meta' takes precedence over the one of the HTTP request
+func (p *MCPProxy) startRequestSpan(ctx context.Context, id any, method mcp.MCPMethod, message any) {
+	if callToolRequest, ok := message.(*mcp.CallToolRequest); ok {
+		ctx = tracing.ExtractMeta(ctx, callToolRequest.Params.Meta)
+	}
+
+	attributes := []attribute.KeyValue{
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", string(method)),
+		attribute.String("rpc.jsonrpc.request_id", fmt.Sprint(id)),
+	}
+
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		attributes = append(attributes, attribute.String("mcp.session.id", session.SessionID()))
+	}
+
+	_, span := tracing.Tracer().Start(ctx, string(method),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attributes...))
+
+	p.RequestSpans.Store(getRequestSpanKey(message), span)
+}
+
+// endRequestSpan ends the span of a frontend request, recording the error when there is one
+func (p *MCPProxy) endRequestSpan(message any, err error) {
+	span, ok := p.RequestSpans.LoadAndDelete(getRequestSpanKey(message))
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		span.(trace.Span).RecordError(err)
+		span.(trace.Span).SetStatus(codes.Error, err.Error())
+	}
+	span.(trace.Span).End()
+}
+
+// ToolHandlerMiddleware makes the span of the frontend request the parent of the spans
+// created while the tool is handled, such as the backend calls
+func (p *MCPProxy) ToolHandlerMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if request.Params.Meta == nil || request.Params.Meta.AdditionalFields[requestKeyMetaField] == nil {
+			return next(ctx, request)
+		}
+
+		if span, ok := p.RequestSpans.Load(request.Params.Meta.AdditionalFields[requestKeyMetaField]); ok {
+			ctx = trace.ContextWithSpan(ctx, span.(trace.Span))
+		}
+
+		return next(ctx, request)
+	}
+}
+
+// startBackendSpan starts the span of a request sent to the backend
+func startBackendSpan(ctx context.Context, method string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", method))
+
+	return tracing.Tracer().Start(ctx, "backend "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+}
+
+// endBackendSpan ends the span of a request sent to the backend, recording the error when there is one
+func endBackendSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -11,6 +11,7 @@ import (
 
 	//
 	"mcp-proxy/internal/cache"
+	"mcp-proxy/internal/metrics"
 )
 
 // handleToolCallTool execute the tool from the backend
@@ -39,16 +40,111 @@ func (tm *ToolsManager) handleToolCallTool(ctx context.Context, request mcp.Call
 	// Delete tool prefix when existing
 	backendToolName := mapToolName(name)
 
-	// Craft and execute backend request
+	// Look the tool up once, as it may require asking the backend.
+	// Unknown tools are still called, so the backend answers them
+	var tool *mcp.Tool
+	backendTool, exists, err := tm.dependencies.Proxy.GetBackendTool(ctx, backendToolName)
+	if err != nil {
+		tm.dependencies.AppCtx.Logger.Error("failed getting tool definition from backend", "tool", backendToolName, "error", err.Error())
+	}
+	if err == nil && exists {
+		tool = &backendTool
+	}
+	toolLabel := getToolMetricLabel(tool)
+
+	// Look for a memoized result when the tool is read-only
+	resultsCacheKey := tm.getResultsCacheKey(ctx, tool, args)
+	if resultsCacheKey != "" {
+		if result, found := tm.dependencies.Proxy.ResultsCache.Get(resultsCacheKey); found {
+			metrics.CacheLookupsTotal.WithLabelValues("results", "hit").Inc()
+			metrics.ToolCallsTotal.WithLabelValues(toolLabel, "cached").Inc()
+			return tm.cacheBigResult(result), nil
+		}
+		metrics.CacheLookupsTotal.WithLabelValues("results", "miss").Inc()
+	}
+
+	// Let the client cancel the call while it is in flight
+	ctx, untrackCall := tm.dependencies.Proxy.TrackCall(ctx, request)
+	defer untrackCall()
+
+	// Craft and execute backend request.
+	// Progress token is forwarded so the backend progress is relayed to the client
 	backendRequest := mcp.CallToolRequest{}
 	backendRequest.Params.Name = backendToolName
 	backendRequest.Params.Arguments = args
+	if request.Params.Meta != nil && request.Params.Meta.ProgressToken != nil {
+		backendRequest.Params.Meta = &mcp.Meta{ProgressToken: request.Params.Meta.ProgressToken}
+	}
+
+	callStart := time.Now()
+	result, err := tm.dependencies.Proxy.CallTool(ctx, backendRequest)
+	metrics.ToolCallDuration.WithLabelValues(toolLabel).Observe(time.Since(callStart).Seconds())
 
-	result, err := tm.dependencies.Proxy.McpClient.CallTool(ctx, backendRequest)
 	if err != nil {
+		metrics.ToolCallsTotal.WithLabelValues(toolLabel, "backend_error").Inc()
 		return mcp.NewToolResultError(fmt.Sprintf("Backend tool execution failed: %v", err)), nil
 	}
 
+	if result.IsError {
+		metrics.ToolCallsTotal.WithLabelValues(toolLabel, "tool_error").Inc()
+	} else {
+		metrics.ToolCallsTotal.WithLabelValues(toolLabel, "ok").Inc()
+	}
+
+	// Errors are not memoized, as they are commonly transient
+	if resultsCacheKey != "" && !result.IsError {
+		tm.dependencies.Proxy.ResultsCache.Set(resultsCacheKey, result,
+			getResultsCacheTTL(tm.dependencies.AppCtx.Config.Backend.ToolResultsCache, backendToolName))
+	}
+
+	return tm.cacheBigResult(result), nil
+}
+
+// getToolMetricLabel returns the tool name used to label metrics.
+// Names not exposed by the backend are grouped, so clients can not create unbounded series
+func getToolMetricLabel(tool *mcp.Tool) string {
+	if tool == nil {
+		return "unknown"
+	}
+	return tool.Name
+}
+
+// getResultsCacheKey returns the key to memoize the result of a call to a backend tool.
+// Empty key is returned when the result must not be memoized, or the tool is not exposed by the backend (nil)
+func (tm *ToolsManager) getResultsCacheKey(ctx context.Context, tool *mcp.Tool, args map[string]interface{}) string {
+	// Only tools declared as read-only by the backend are memoized
+	if tool == nil || tool.Annotations.ReadOnlyHint == nil || !*tool.Annotations.ReadOnlyHint {
+		return ""
+	}
+
+	toolName := tool.Name
+	resultsCacheConfig := tm.dependencies.AppCtx.Config.Backend.ToolResultsCache
+	if !resultsCacheConfig.Enabled || getResultsCacheTTL(resultsCacheConfig, toolName) <= 0 {
+		return ""
+	}
+
+	// Sharing results between users is not safe when they are scoped per user
+	user := ""
+	if resultsCacheConfig.PerUser {
+		user = getUserIdentity(ctx)
+		if user == "" {
+			return ""
+		}
+	}
+
+	key, err := cache.GenerateResultsCacheKey(toolName, args, user)
+	if err != nil {
+		tm.dependencies.AppCtx.Logger.Error("failed generating results cache key", "tool", toolName, "error", err.Error())
+		return ""
+	}
+
+	return key
+}
+
+// cacheBigResult stores big results in the cache, returning a reference to them instead.
+// Small results are returned as they are
+func (tm *ToolsManager) cacheBigResult(result *mcp.CallToolResult) *mcp.CallToolResult {
+
 	// For big response, use cache to store it
 	resultJson, _ := json.Marshal(result)
 	if len(resultJson) > tm.dependencies.AppCtx.Config.Server.Options.CacheThresholdBytes {
@@ -57,6 +153,7 @@ func (tm *ToolsManager) handleToolCallTool(ctx context.Context, request mcp.Call
 		tm.dependencies.Proxy.Cache.Registry[cacheKey] = cache.CacheEntry{
 			Data:      result,
 			Timestamp: time.Now().Unix(),
+			Bytes:     len(resultJson),
 		}
 		tm.dependencies.Proxy.Cache.Mu.Unlock()
 
@@ -67,8 +164,8 @@ func (tm *ToolsManager) handleToolCallTool(ctx context.Context, request mcp.Call
 			"message":   fmt.Sprintf("Response cached due to size. Use read_cache with key: %s", cacheKey),
 		}
 		cacheBytes, _ := json.Marshal(cacheResponse)
-		return mcp.NewToolResultText(string(cacheBytes)), nil
+		return mcp.NewToolResultText(string(cacheBytes))
 	}
 
-	return result, nil
+	return result
 }
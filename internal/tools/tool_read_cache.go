@@ -7,6 +7,9 @@ import (
 
 	//
 	"github.com/mark3labs/mcp-go/mcp"
+
+	//
+	"mcp-proxy/internal/metrics"
 )
 
 // handleToolReadCache read cached data with pagination
@@ -39,8 +42,10 @@ func (tm *ToolsManager) handleToolReadCache(ctx context.Context, request mcp.Cal
 	tm.dependencies.Proxy.Cache.Mu.RUnlock()
 
 	if !exists {
+		metrics.CacheLookupsTotal.WithLabelValues("responses", "miss").Inc()
 		return mcp.NewToolResultError(fmt.Sprintf("Cache key not found: %s", key)), nil
 	}
+	metrics.CacheLookupsTotal.WithLabelValues("responses", "hit").Inc()
 
 	// Paginate data when needed
 	paginatedData := paginateData(entry.Data, offset, limit)
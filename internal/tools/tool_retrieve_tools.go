@@ -20,8 +20,7 @@ func (tm *ToolsManager) handleToolRetrieveTools(ctx context.Context, request mcp
 	query := request.GetString("query", "")
 
 	// Get the list of tools from backend
-	listRequest := mcp.ListToolsRequest{}
-	listResult, err := tm.dependencies.Proxy.McpClient.ListTools(ctx, listRequest)
+	listResult, err := tm.dependencies.Proxy.ListBackendTools(ctx)
 	if err != nil {
 		return mcp.NewToolResultError(fmt.Sprintf("Failed to list tools from backend: %v", err)), nil
 	}
@@ -1,10 +1,17 @@
 package tools
 
 import (
+	"context"
+	"path"
 	"strings"
+	"time"
 
 	//
 	"github.com/mark3labs/mcp-go/mcp"
+
+	//
+	"mcp-proxy/api"
+	"mcp-proxy/internal/middlewares"
 )
 
 // isRelevantTool decides whether a tool is relevant based
@@ -50,3 +57,25 @@ func paginateData(data interface{}, offset, limit int) interface{} {
 		return data
 	}
 }
+
+// getResultsCacheTTL returns the TTL for memoizing results of a tool.
+// First rule matching the tool name wins. Default TTL is used when none matches
+func getResultsCacheTTL(config api.BackendToolResultsCacheConfig, toolName string) time.Duration {
+	for _, rule := range config.Rules {
+		if matched, _ := path.Match(rule.Tool, toolName); matched {
+			return rule.TTL
+		}
+	}
+	return config.DefaultTTL
+}
+
+// getUserIdentity returns the subject of the validated JWT present in the context, if any
+func getUserIdentity(ctx context.Context) string {
+	payload, ok := middlewares.JWTPayloadFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	sub, _ := payload["sub"].(string)
+	return sub
+}
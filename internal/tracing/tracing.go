@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	//
+	"mcp-proxy/internal/globals"
+)
+
+const tracerName = "mcp-proxy"
+
+// Tracer returns the tracer used for the spans of the proxy.
+// Spans are neither recorded nor propagated until tracing is set up
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Setup configures the global tracer provider and the W3C propagators.
+// Returned function flushes the pending spans, and must be called before exiting
+func Setup(appCtx *globals.ApplicationContext) (func(context.Context) error, error) {
+	tracingConfig := appCtx.Config.Server.Tracing
+	if !tracingConfig.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Propagators are only set when tracing is enabled, so nothing from the clients reaches the backend otherwise.
+	// Baggage may carry arbitrary data, so it is only propagated when asked
+	propagators := []propagation.TextMapPropagator{propagation.TraceContext{}}
+	if tracingConfig.Baggage {
+		propagators = append(propagators, propagation.Baggage{})
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagators...))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch tracingConfig.Exporter {
+	case "otlp":
+		options := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(tracingConfig.OTLP.Endpoint),
+			otlptracehttp.WithHeaders(tracingConfig.OTLP.Headers),
+		}
+		if *tracingConfig.OTLP.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(appCtx.Context, options...)
+
+	case "stdout":
+		// Stdout carries the MCP messages on stdio transport, so spans are written to stderr there
+		writer := os.Stdout
+		if appCtx.Config.Server.Transport.Type != "http" {
+			writer = os.Stderr
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter: %s", tracingConfig.Exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed creating tracing exporter: %s", err.Error())
+	}
+
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", appCtx.Config.Server.Name),
+		attribute.String("service.version", appCtx.Config.Server.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating tracing resource: %s", err.Error())
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*tracingConfig.SampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	return tracerProvider.Shutdown, nil
+}
+
+// MetaCarrier adapts the '_meta' field of MCP requests to carry the trace context,
+// the same way HTTP headers do. Ref: https://www.w3.org/TR/trace-context/
+type MetaCarrier map[string]any
+
+func (c MetaCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c MetaCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c MetaCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ExtractMeta returns a context with the trace context found in the '_meta' of a request.
+// The given context is returned when there is none
+func ExtractMeta(ctx context.Context, meta *mcp.Meta) context.Context {
+	if meta == nil || len(meta.AdditionalFields) == 0 {
+		return ctx
+	}
+
+	metaCtx := otel.GetTextMapPropagator().Extract(ctx, MetaCarrier(meta.AdditionalFields))
+	if !trace.SpanContextFromContext(metaCtx).IsValid() {
+		return ctx
+	}
+	return metaCtx
+}
+
+// InjectMeta returns a copy of the '_meta' of a request carrying the trace context of ctx.
+// The original is not modified, as it can be shared with the frontend request
+func InjectMeta(ctx context.Context, meta *mcp.Meta) *mcp.Meta {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return meta
+	}
+
+	injectedMeta := &mcp.Meta{AdditionalFields: map[string]any{}}
+	if meta != nil {
+		injectedMeta.ProgressToken = meta.ProgressToken
+		for key, value := range meta.AdditionalFields {
+			injectedMeta.AdditionalFields[key] = value
+		}
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, MetaCarrier(injectedMeta.AdditionalFields))
+	return injectedMeta
+}
+
+// GetHTTPHeaders returns the headers carrying the trace context of ctx, to be sent to the backend
+func GetHTTPHeaders(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}